@@ -3,12 +3,18 @@ package main
 import (
 	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/spoonboy-io/dozer/internal/admin"
+	"github.com/spoonboy-io/dozer/internal/deadletter"
+	"github.com/spoonboy-io/dozer/internal/queue"
+
 	"github.com/spoonboy-io/dozer/internal/hook"
 	"github.com/spoonboy-io/reprise"
 
@@ -34,6 +40,11 @@ var (
 var logger *koan.Logger
 var st *state.State
 
+var (
+	listDeadLetters  = flag.Bool("deadletters", false, "list webhook deliveries which failed after all attempts and exit")
+	replayDeadLetter = flag.String("replay", "", "replay a failed webhook delivery by id, or 'all', and exit")
+)
+
 func init() {
 	st = &state.State{}
 	logger = &koan.Logger{}
@@ -55,27 +66,85 @@ func init() {
 }
 
 // Shutdown runs on SIGINT and panic, we save the database poll state
-// which will be loaded upon application restart
-func Shutdown(db *sql.DB, cancel context.CancelFunc) {
+// which will be loaded upon application restart. Polls are stopped first, and any in progress
+// finished, so webhooks they fire are queued before the queue is closed and the state saved
+func Shutdown(db *sql.DB, stopPolls context.CancelFunc, polls *sync.WaitGroup, cancel context.CancelFunc) {
 	fmt.Println("") // break after ^C
 	logger.Warn("Application terminated")
+	logger.Info("Stopping database polls")
+	stopPolls()
+	polls.Wait()
+
 	logger.Info("Closing database connection")
 	db.Close()
 
-	// cancel the context so we can stop our http client and in progress http requests
+	// cancel the context so we can stop our http client and in progress http requests, deliveries
+	// in progress remain queued and are made when the application is restarted
 	logger.Info("Cancelling HTTP client requests")
 	cancel()
 
+	if hook.Queue != nil {
+		logger.Info("Closing delivery queue")
+		if err := hook.Queue.Close(); err != nil {
+			logger.Error("Failed to close delivery queue", err)
+		}
+	}
+
 	logger.Info("Saving application state")
 	if err := st.CreateAndWrite(); err != nil {
 		logger.Error("Failed to save application state", err)
 	}
 }
 
+// replay delivers dead letters again, by id or all of them
+func replay(ctx context.Context, which string) {
+	var ids []int
+	if which == "all" {
+		for _, letter := range hook.DeadLetters.List() {
+			ids = append(ids, letter.Id)
+		}
+	} else {
+		id, err := strconv.Atoi(which)
+		if err != nil {
+			logger.FatalError("Replay requires a dead letter id or 'all'", err)
+		}
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+		if err := hook.Replay(ctx, id, logger); err != nil {
+			logger.Error(fmt.Sprintf("Failed to replay dead letter %d", id), err)
+			continue
+		}
+		logger.Info(fmt.Sprintf("Replayed dead letter %d", id))
+	}
+}
+
 func main() {
 	var firstRun bool
 	ctx, cancel := context.WithCancel(context.Background())
 
+	flag.Parse()
+
+	// load the dead letters, deliveries which failed are added as we run
+	hook.DeadLetters = deadletter.New(deadletter.FILE_NAME)
+	if err := hook.DeadLetters.ReadAndParse(); err != nil {
+		logger.FatalError("Failed to read or parse dead letters", err)
+	}
+
+	if *listDeadLetters {
+		for _, letter := range hook.DeadLetters.List() {
+			fmt.Printf("%d\t%s\thook: '%s'\tprocess id: %d\tevent: %s\tattempts: %d\terror: %s\n",
+				letter.Id, letter.Failed.Format(time.RFC3339), letter.Hook, letter.ProcessId, letter.Event, letter.Attempts, letter.Error)
+		}
+		return
+	}
+
+	if *replayDeadLetter != "" {
+		replay(ctx, *replayDeadLetter)
+		return
+	}
+
 	// write a console banner
 	reprise.WriteSimple(&reprise.Banner{
 		Name:         "Dozer",
@@ -99,9 +168,18 @@ func main() {
 		firstRun = true
 	}
 
+	// open the delivery queue, webhooks queued but not delivered before the last shutdown are recovered
+	var err error
+	hook.Queue, err = queue.Open(queue.FILE_NAME)
+	if err != nil {
+		logger.FatalError("Failed to open delivery queue", err)
+	}
+	if pending := len(hook.Queue.Pending()); pending > 0 {
+		logger.Info(fmt.Sprintf("Recovered %d queued webhook deliveries", pending))
+	}
+
 	// connect to database
 	var db *sql.DB
-	var err error
 
 	// Fixes https://github.com/spoonboy-io/dozer/issues/2
 	// here we make the database name configurable but need to set 'morpheus' as default, so we don't need a major version change
@@ -117,7 +195,10 @@ func main() {
 		logger.FatalError("Failed to create database connection", err)
 	}
 
-	defer Shutdown(db, cancel)
+	// polls run until stopped by Shutdown, which waits for them to finish
+	var polls sync.WaitGroup
+	pollCtx, stopPolls := context.WithCancel(ctx)
+	defer Shutdown(db, stopPolls, &polls, cancel)
 
 	if err = db.Ping(); err != nil {
 		logger.FatalError("Failed to connect to database", err)
@@ -130,6 +211,20 @@ func main() {
 		logger.FatalError("Failed to load process types", err)
 	}
 
+	if os.Getenv("ENRICH_PROCESSES") == "true" {
+		logger.Info("Using ENRICH_PROCESSES environment variable, processes will be enriched with instance, cloud and server")
+		morpheus.Enrichment = true
+	}
+
+	// accounts and users only enrich processes, without them we carry on and the refresh tries again
+	logger.Info("Loading accounts and users from database")
+	if err := morpheus.GetAccounts(db); err != nil {
+		logger.Warn(fmt.Sprintf("Could not load accounts, continuing without them until refreshed: %v", err))
+	}
+	if err := morpheus.GetUsers(db); err != nil {
+		logger.Warn(fmt.Sprintf("Could not load users, continuing without them until refreshed: %v", err))
+	}
+
 	if firstRun {
 		// first run so we'll set the lastProcessId of state
 		logger.Warn("No state detected, capturing last process id")
@@ -139,7 +234,51 @@ func main() {
 		firstRun = false
 	}
 
+	if os.Getenv("BREAKER_FAILURES") != "" {
+		if hook.BreakerFailures, err = strconv.Atoi(os.Getenv("BREAKER_FAILURES")); err != nil || hook.BreakerFailures < 1 {
+			logger.Warn("Could not use BREAKER_FAILURES, continuing with default")
+			hook.BreakerFailures = internal.BREAKER_FAILURES
+		}
+		logger.Info("Using BREAKER_FAILURES environment variable")
+	}
+	if os.Getenv("BREAKER_COOLDOWN_SECONDS") != "" {
+		cooldownSecs, err := strconv.Atoi(os.Getenv("BREAKER_COOLDOWN_SECONDS"))
+		if err != nil {
+			logger.Warn("Could not use BREAKER_COOLDOWN_SECONDS, continuing with default")
+		} else {
+			hook.BreakerCooldown = time.Duration(cooldownSecs) * time.Second
+		}
+		logger.Info("Using BREAKER_COOLDOWN_SECONDS environment variable")
+	}
+
+	if os.Getenv("DELIVERY_WORKERS") != "" {
+		if hook.Workers, err = strconv.Atoi(os.Getenv("DELIVERY_WORKERS")); err != nil || hook.Workers < 1 {
+			logger.Warn("Could not use DELIVERY_WORKERS, continuing with default")
+			hook.Workers = internal.DELIVERY_WORKERS
+		}
+		logger.Info("Using DELIVERY_WORKERS environment variable")
+	}
+	if os.Getenv("QUEUE_LIMIT") != "" {
+		if hook.QueueLimit, err = strconv.Atoi(os.Getenv("QUEUE_LIMIT")); err != nil || hook.QueueLimit < 1 {
+			logger.Warn("Could not use QUEUE_LIMIT, continuing with default")
+			hook.QueueLimit = internal.QUEUE_LIMIT
+		}
+		logger.Info("Using QUEUE_LIMIT environment variable")
+	}
+
+	// serve the admin endpoints, such as circuit breaker state
+	if addr := os.Getenv("ADMIN_ADDRESS"); addr != "" {
+		logger.Info(fmt.Sprintf("Serving admin endpoints on %s", addr))
+		go admin.Serve(ctx, addr, logger)
+	}
+
+	// deliver queued webhooks as they are matched by the polls
+	go hook.ProcessQueue(ctx, logger)
+
+	polls.Add(1)
 	go func() {
+		defer polls.Done()
+
 		pollSecs := internal.POLL_INTERVAL
 		if os.Getenv("POLL_INTERVAL_SECONDS") != "" {
 			if pollSecs, err = strconv.Atoi(os.Getenv("POLL_INTERVAL_SECONDS")); err != nil {
@@ -148,21 +287,78 @@ func main() {
 			logger.Info("Using POLL_INTERVAL_SECCONDS environment variable")
 		}
 		pollInterval := time.NewTicker(time.Duration(pollSecs) * time.Second)
-		for range pollInterval.C {
-			if err = morpheus.CheckExecuting(ctx, db, st, logger); err != nil {
+		defer pollInterval.Stop()
+		for {
+			select {
+			case <-pollCtx.Done():
+				return
+			case <-pollInterval.C:
+			}
+
+			if err = morpheus.CheckExecuting(pollCtx, db, st, logger); err != nil {
 				logger.Error("Error handling executing processes", err)
 			}
 
-			if err := morpheus.GetProcesses(ctx, db, st, logger); err != nil {
+			if err := morpheus.GetProcesses(pollCtx, db, st, logger); err != nil {
 				logger.Error("Database poll error", err)
 			}
 
+			// workflows are sent once all their processes from the poll have been seen
+			hook.SendWorkflows(pollCtx, st, logger)
+
+			// summaries are sent for suppression windows which have closed
+			hook.SendSuppressed(pollCtx, st, logger)
+
+			// threshold counts which have left their window are dropped
+			hook.PruneThresholds(st)
+
 			lastPollMsg := fmt.Sprintf("Last datasbase poll performed at %s (lastProcessId: %d, tracking executing; %d)",
 				st.LastPollTimestamp, st.LastPollProcessId, len(st.ExecutingProcesses))
 			logger.Info(lastPollMsg)
 		}
 	}()
 
+	// accounts and users are refreshed so new tenants and users are enriched
+	polls.Add(1)
+	go func() {
+		defer polls.Done()
+
+		directoryInterval := time.NewTicker(internal.DIRECTORY_REFRESH_INTERVAL * time.Second)
+		defer directoryInterval.Stop()
+		for {
+			select {
+			case <-pollCtx.Done():
+				return
+			case <-directoryInterval.C:
+			}
+
+			if err := morpheus.GetAccounts(db); err != nil {
+				logger.Error("Failed to refresh accounts", err)
+			}
+			if err := morpheus.GetUsers(db); err != nil {
+				logger.Error("Failed to refresh users", err)
+			}
+		}
+	}()
+
+	// absence hooks fire when an expected process is not seen, so are checked on their own schedule
+	polls.Add(1)
+	go func() {
+		defer polls.Done()
+
+		absenceInterval := time.NewTicker(internal.ABSENCE_INTERVAL * time.Second)
+		defer absenceInterval.Stop()
+		for {
+			select {
+			case <-pollCtx.Done():
+				return
+			case <-absenceInterval.C:
+			}
+
+			hook.CheckAbsence(pollCtx, st, logger)
+		}
+	}()
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
@@ -5,10 +5,18 @@ go 1.17
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/google/cel-go v0.12.6
 	github.com/joho/godotenv v1.4.0
 	github.com/spoonboy-io/koan v0.1.0
 	github.com/spoonboy-io/reprise v0.0.1
 	gopkg.in/yaml.v2 v2.4.0
 )
 
-require github.com/TwiN/go-color v1.1.0 // indirect
+require (
+	github.com/TwiN/go-color v1.1.0 // indirect
+	github.com/antlr/antlr4/runtime/Go/antlr v0.0.0-20220418222510-f25a4f6275ed // indirect
+	github.com/stoewer/go-strcase v1.2.0 // indirect
+	golang.org/x/text v0.3.7 // indirect
+	google.golang.org/genproto v0.0.0-20220502173005-c8bf987b8c21 // indirect
+	google.golang.org/protobuf v1.28.0 // indirect
+)
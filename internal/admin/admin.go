@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/spoonboy-io/dozer/internal/hook"
+	"github.com/spoonboy-io/koan"
+)
+
+// Handler returns the handler of the admin endpoints
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/breakers", breakers)
+	return mux
+}
+
+// Serve runs the admin server on the address until the context is cancelled
+func Serve(ctx context.Context, addr string, logger *koan.Logger) {
+	srv := &http.Server{Addr: addr, Handler: Handler()}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("Admin server error", err)
+	}
+}
+
+// breakers responds with the state of the circuit breaker of each webhook host
+func breakers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hook.Breakers()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,43 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spoonboy-io/dozer/internal/admin"
+	"github.com/spoonboy-io/dozer/internal/hook"
+)
+
+func TestBreakers(t *testing.T) {
+	server := httptest.NewServer(admin.Handler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/breakers")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("wanted status 200 got %d", res.StatusCode)
+	}
+
+	var got []hook.BreakerStatus
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("wanted no breakers got %v", got)
+	}
+
+	res, err = http.Post(server.URL+"/breakers", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("wanted status 405 got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,172 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const FILE_NAME = "dozer.deadletter"
+
+var ERR_NOT_FOUND = errors.New("dead letter not found")
+
+// Letter is a webhook delivery which failed after all attempts, it holds the rendered request body
+// and headers so it can be replayed as it would have been sent
+type Letter struct {
+	Id        int               `json:"id"`
+	Hook      string            `json:"hook"`
+	ProcessId int               `json:"processId"`
+	Event     string            `json:"event"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Body      string            `json:"body"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Attempts  int               `json:"attempts"`
+	Error     string            `json:"error"`
+	Failed    time.Time         `json:"failed"`
+}
+
+// Store holds dead letters, it is written to file on every change so they are not lost if the
+// application is terminated. Changes are made with the file locked and re-read, so a replay run while
+// the application is running does not lose changes made by either
+type Store struct {
+	LastId  int      `json:"lastId"`
+	Letters []Letter `json:"letters"`
+
+	file string
+	mu   sync.Mutex
+}
+
+// New returns a store which reads and writes the file
+func New(file string) *Store {
+	return &Store{file: file}
+}
+
+// ReadAndParse reads the store from file, a store which has not been written is empty
+func (s *Store) ReadAndParse() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read()
+}
+
+// Add gives the letter an id and adds it to the store
+func (s *Store) Add(letter Letter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.modify(func() error {
+		s.LastId++
+		letter.Id = s.LastId
+		letter.Failed = letter.Failed.Round(0)
+		s.Letters = append(s.Letters, letter)
+		return nil
+	})
+}
+
+// List returns a copy of the letters in the store
+func (s *Store) List() []Letter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Letter(nil), s.Letters...)
+}
+
+// Get returns the letter with the id
+func (s *Store) Get(id int) (Letter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, letter := range s.Letters {
+		if letter.Id == id {
+			return letter, nil
+		}
+	}
+	return Letter{}, fmt.Errorf("%w: id %d", ERR_NOT_FOUND, id)
+}
+
+// Update replaces the letter with the same id, e.g. after a replay which failed
+func (s *Store) Update(letter Letter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.modify(func() error {
+		for i := range s.Letters {
+			if s.Letters[i].Id == letter.Id {
+				s.Letters[i] = letter
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: id %d", ERR_NOT_FOUND, letter.Id)
+	})
+}
+
+// Remove deletes the letter with the id, e.g. after it has been replayed
+func (s *Store) Remove(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.modify(func() error {
+		for i := range s.Letters {
+			if s.Letters[i].Id == id {
+				s.Letters = append(s.Letters[:i], s.Letters[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: id %d", ERR_NOT_FOUND, id)
+	})
+}
+
+// modify makes the change with the file locked, re-reading the store first so changes made by another
+// process are kept, and writes it if the change succeeds. The lock must be held
+func (s *Store) modify(change func() error) error {
+	unlock, err := lockFile(s.file + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.read(); err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	return s.write()
+}
+
+// read replaces the store with the contents of the file, the lock must be held
+func (s *Store) read() error {
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored Store
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	s.LastId, s.Letters = stored.LastId, stored.Letters
+	return nil
+}
+
+// write marshals the store to JSON and replaces the file with it, so a reader never sees it part
+// written. The lock must be held
+func (s *Store) write() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
@@ -0,0 +1,100 @@
+package deadletter_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spoonboy-io/dozer/internal/deadletter"
+)
+
+func TestStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), deadletter.FILE_NAME)
+
+	store := deadletter.New(file)
+	if err := store.ReadAndParse(); err != nil {
+		t.Fatalf("Unexpected error reading store which has not been written %v", err)
+	}
+
+	for _, hook := range []string{"hook 1", "hook 2", "hook 3"} {
+		if err := store.Add(deadletter.Letter{Hook: hook, Attempts: 3}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if err := store.Remove(2); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := store.Remove(2); !errors.Is(err, deadletter.ERR_NOT_FOUND) {
+		t.Errorf("failed got %v wanted %v", err, deadletter.ERR_NOT_FOUND)
+	}
+
+	letter, err := store.Get(3)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	letter.Attempts = 4
+	if err := store.Update(letter); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// read back from file
+	got := deadletter.New(file)
+	if err := got.ReadAndParse(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	want := []deadletter.Letter{
+		{Id: 1, Hook: "hook 1", Attempts: 3},
+		{Id: 3, Hook: "hook 3", Attempts: 4},
+	}
+	if !reflect.DeepEqual(got.List(), want) {
+		t.Errorf("failed got %v wanted %v", got.List(), want)
+	}
+
+	// ids are not reused
+	if err := got.Add(deadletter.Letter{Hook: "hook 4"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := got.Get(4); err != nil {
+		t.Errorf("failed got %v wanted letter id 4", err)
+	}
+}
+
+func TestStoreSharedFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), deadletter.FILE_NAME)
+
+	// the running application and a replay each hold the store
+	running := deadletter.New(file)
+	for _, hook := range []string{"hook 1", "hook 2"} {
+		if err := running.Add(deadletter.Letter{Hook: hook}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	replay := deadletter.New(file)
+	if err := replay.ReadAndParse(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := replay.Remove(1); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// a letter added by the running application keeps the replay's change
+	if err := running.Add(deadletter.Letter{Hook: "hook 3"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := replay.Remove(3); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	got := deadletter.New(file)
+	if err := got.ReadAndParse(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	want := []deadletter.Letter{{Id: 2, Hook: "hook 2"}}
+	if !reflect.DeepEqual(got.List(), want) || got.LastId != 3 {
+		t.Errorf("failed got %v and last id %d wanted %v and 3", got.List(), got.LastId, want)
+	}
+}
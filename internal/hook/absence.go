@@ -0,0 +1,56 @@
+package hook
+
+import (
+	"context"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal/state"
+	"github.com/spoonboy-io/koan"
+)
+
+// seen records a process matching an absence hook, moving its deadline on. The process end date is
+// used as the time it was seen, so the deadline is unaffected by when the process was polled
+func (h *Hook) seen(data *templateData, st *state.State) {
+	at := data.EndDate
+	if at.IsZero() {
+		at = time.Now()
+	}
+	st.Seen(h.Description, at, at.Add(h.absentAfter))
+}
+
+// CheckAbsence is called by the scheduler to fire absence hooks which have not seen a matching process
+// before their deadline. Once fired the deadline is moved on, so the hook fires again each period the
+// process is still absent. Hooks without a deadline, i.e. on first run, are given one from now
+func CheckAbsence(ctx context.Context, st *state.State, logger *koan.Logger) {
+	now := time.Now()
+	for i := range config {
+		hook := &config[i].Hook
+		if hook.absentAfter == 0 {
+			continue
+		}
+
+		expected, ok := st.Expected(hook.Description)
+		if !ok {
+			st.SetDeadline(hook.Description, now.Add(hook.absentAfter))
+			continue
+		}
+
+		if now.Before(expected.Deadline) {
+			continue
+		}
+
+		// the deadline is moved on from when it passed, not now, so a restart doesn't shift the schedule
+		deadline := expected.Deadline
+		for !now.Before(deadline) {
+			deadline = deadline.Add(hook.absentAfter)
+		}
+		st.SetDeadline(hook.Description, deadline)
+
+		data := &templateData{
+			Event:    EVENT_ABSENT,
+			Matches:  map[string][]string{},
+			LastSeen: expected.LastSeen,
+		}
+		sendWebhook(ctx, data, hook, logger)
+	}
+}
@@ -0,0 +1,68 @@
+package hook
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
+	"github.com/spoonboy-io/koan"
+)
+
+func TestCheckAbsence(t *testing.T) {
+	ctx := context.Background()
+	logger := &koan.Logger{}
+	st := &state.State{}
+
+	server := newTestServer(t)
+
+	config = Hooks{
+		{
+			Hook{
+				Description: "nightly backup missing",
+				URL:         server.URL,
+				Method:      "POST",
+				RequestBody: `{{.Event}} {{.LastSeen.Format "2006-01-02T15:04"}}`,
+				Absence:     "26h",
+				Triggers: Trigger{
+					"jobTemplateName": "Nightly Backup",
+					"status":          "complete",
+				},
+			},
+		},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
+
+	// first run, the deadline is set from now
+	CheckAbsence(ctx, st, logger)
+	server.expectNoRequest(t)
+	if exp, ok := st.Expected("nightly backup missing"); !ok || time.Until(exp.Deadline) < 25*time.Hour {
+		t.Errorf("wanted deadline in 26h got %v", exp.Deadline)
+	}
+
+	// a matching process is seen 27 hours ago, the hook does not fire on the process itself
+	lastSeen := time.Now().Add(-27 * time.Hour).UTC()
+	CheckProcess(ctx, &internal.Process{
+		Id:              3,
+		Status:          "complete",
+		JobTemplateName: sql.NullString{String: "Nightly Backup"},
+		EndDate:         sql.NullTime{Time: lastSeen, Valid: true},
+	}, st, logger)
+	server.expectNoRequest(t)
+
+	// the deadline has passed
+	CheckAbsence(ctx, st, logger)
+	server.expectRequest(t, "absent "+lastSeen.Format("2006-01-02T15:04"))
+
+	// the deadline was moved on so it doesn't fire again
+	CheckAbsence(ctx, st, logger)
+	server.expectNoRequest(t)
+	if exp, _ := st.Expected("nightly backup missing"); time.Until(exp.Deadline) < 24*time.Hour {
+		t.Errorf("wanted deadline moved on got %v", exp.Deadline)
+	}
+}
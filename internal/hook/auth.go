@@ -0,0 +1,202 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authentication types
+const (
+	AUTH_OAUTH2  = "oauth2"
+	AUTH_BASIC   = "basic"
+	AUTH_API_KEY = "apiKey"
+)
+
+// tokenExpiryLeeway is taken off the lifetime of access tokens so they are refreshed before they expire
+const tokenExpiryLeeway = 30 * time.Second
+
+// Auth configures how a hook authenticates with the webhook.
+//
+// oauth2 uses the client credentials grant, fetching an access token from TokenURL with the ClientId,
+// ClientSecret and Scopes, which is cached until it expires. basic sends the Username and Password.
+// apiKey sends the Key in the header, or query parameter when In is query, called Name
+type Auth struct {
+	Type         string   `yaml:"type"`
+	TokenURL     string   `yaml:"tokenURL"`
+	ClientId     string   `yaml:"clientId"`
+	ClientSecret string   `yaml:"clientSecret"`
+	Scopes       []string `yaml:"scopes"`
+	Username     string   `yaml:"username"`
+	Password     string   `yaml:"password"`
+	Key          string   `yaml:"key"`
+	Name         string   `yaml:"name"`
+	In           string   `yaml:"in"`
+}
+
+// accessToken is the cached oauth2 access token of a token url, client and scopes, and when it should
+// be refreshed. Its lock is held while fetching so concurrent requests don't each fetch a token
+type accessToken struct {
+	value  string
+	expiry time.Time
+	mu     sync.Mutex
+}
+
+// tokens caches access tokens for each token url, client and scopes, tokensMu guards the map only
+// so a slow token url does not hold up hooks using others
+var (
+	tokens   = map[string]*accessToken{}
+	tokensMu sync.Mutex
+)
+
+// compile checks the settings required by the type are present
+func (a *Auth) compile() error {
+	switch a.Type {
+	case AUTH_OAUTH2:
+		if u, err := url.ParseRequestURI(a.TokenURL); err != nil || u.Host == "" {
+			return fmt.Errorf("%w: oauth2 requires a tokenURL", ERR_BAD_AUTH)
+		}
+		if a.ClientId == "" || a.ClientSecret == "" {
+			return fmt.Errorf("%w: oauth2 requires a clientId and clientSecret", ERR_BAD_AUTH)
+		}
+	case AUTH_BASIC:
+		if a.Username == "" {
+			return fmt.Errorf("%w: basic requires a username", ERR_BAD_AUTH)
+		}
+	case AUTH_API_KEY:
+		if a.Key == "" || a.Name == "" {
+			return fmt.Errorf("%w: apiKey requires a key and name", ERR_BAD_AUTH)
+		}
+		if a.In == "" {
+			a.In = "header"
+		}
+		if a.In != "header" && a.In != "query" {
+			return fmt.Errorf("%w: apiKey in should be header or query", ERR_BAD_AUTH)
+		}
+	default:
+		return fmt.Errorf("%w: type should be oauth2, basic or apiKey", ERR_BAD_AUTH)
+	}
+	return nil
+}
+
+// apply authenticates the request, fetching an access token with the client if required
+func (a *Auth) apply(ctx context.Context, req *http.Request, client *http.Client) error {
+	switch a.Type {
+	case AUTH_OAUTH2:
+		token, err := a.token(ctx, client)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case AUTH_BASIC:
+		req.SetBasicAuth(a.Username, a.Password)
+	case AUTH_API_KEY:
+		if a.In == "query" {
+			query := req.URL.Query()
+			query.Set(a.Name, a.Key)
+			req.URL.RawQuery = query.Encode()
+			return nil
+		}
+		req.Header.Set(a.Name, a.Key)
+	}
+	return nil
+}
+
+// tokenKey identifies the cached access token, hooks with the same token url, client and scopes share it
+func (a *Auth) tokenKey() string {
+	return strings.Join([]string{a.TokenURL, a.ClientId, strings.Join(a.Scopes, " ")}, "|")
+}
+
+// cachedToken returns the cache entry for the access token of the hook, adding it if there is none
+func (a *Auth) cachedToken() *accessToken {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	key := a.tokenKey()
+	t, ok := tokens[key]
+	if !ok {
+		t = &accessToken{}
+		tokens[key] = t
+	}
+	return t
+}
+
+// invalidate expires the cached access token so a new one is fetched
+func (a *Auth) invalidate() {
+	t := a.cachedToken()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.value = ""
+	t.expiry = time.Time{}
+}
+
+// token returns the cached access token, fetching a new one if there is none or it has expired
+func (a *Auth) token(ctx context.Context, client *http.Client) (string, error) {
+	t := a.cachedToken()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.value != "" && time.Now().Before(t.expiry) {
+		return t.value, nil
+	}
+
+	value, expiry, err := a.fetchToken(ctx, client)
+	if err != nil {
+		return "", err
+	}
+	t.value, t.expiry = value, expiry
+	return t.value, nil
+}
+
+// fetchToken requests an access token from the token url using the client credentials grant, returning
+// it with when it should be refreshed
+func (a *Auth) fetchToken(ctx context.Context, client *http.Client) (string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+
+	req, err := http.NewRequest("POST", a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: %v", ERR_AUTH_TOKEN, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.ClientId), url.QueryEscape(a.ClientSecret))
+
+	// an unreachable token url is a problem with it, not the webhook host
+	res, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: %v", ERR_AUTH_TOKEN, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("%w: token url responded %d", ERR_AUTH_TOKEN, res.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, responseLimit)).Decode(&body); err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: %v", ERR_AUTH_TOKEN, err)
+	}
+	if body.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("%w: no access_token in response", ERR_AUTH_TOKEN)
+	}
+
+	// tokens without an expiry are used until they are refused
+	expiry := time.Now().Add(24 * time.Hour * 365)
+	if body.ExpiresIn > 0 {
+		expiry = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - tokenExpiryLeeway)
+	}
+	return body.AccessToken, expiry, nil
+}
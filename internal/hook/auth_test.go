@@ -0,0 +1,176 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthCompile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		auth    Auth
+		wantErr error
+	}{
+		{"oauth2", Auth{Type: AUTH_OAUTH2, TokenURL: "https://auth.internal/token", ClientId: "dozer", ClientSecret: "secret"}, nil},
+		{"oauth2 without token url", Auth{Type: AUTH_OAUTH2, ClientId: "dozer", ClientSecret: "secret"}, ERR_BAD_AUTH},
+		{"oauth2 without secret", Auth{Type: AUTH_OAUTH2, TokenURL: "https://auth.internal/token", ClientId: "dozer"}, ERR_BAD_AUTH},
+		{"basic", Auth{Type: AUTH_BASIC, Username: "dozer", Password: "secret"}, nil},
+		{"basic without username", Auth{Type: AUTH_BASIC, Password: "secret"}, ERR_BAD_AUTH},
+		{"apiKey", Auth{Type: AUTH_API_KEY, Name: "X-Api-Key", Key: "secret"}, nil},
+		{"apiKey in query", Auth{Type: AUTH_API_KEY, Name: "api_key", Key: "secret", In: "query"}, nil},
+		{"apiKey in body", Auth{Type: AUTH_API_KEY, Name: "api_key", Key: "secret", In: "body"}, ERR_BAD_AUTH},
+		{"unknown type", Auth{Type: "digest"}, ERR_BAD_AUTH},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.auth.compile(); !errors.Is(err, tc.wantErr) {
+				t.Errorf("wanted %v got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAuthRequests(t *testing.T) {
+	ctx := context.Background()
+
+	var gotReq *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		gotReq = req
+		rw.Write([]byte(`ok`))
+	}))
+	defer server.Close()
+
+	// basic
+	hook := &Hook{Description: "test hook", URL: server.URL, Method: "GET", Auth: &Auth{Type: AUTH_BASIC, Username: "dozer", Password: "secret"}}
+	if err := deliver(ctx, nil, nil, hook); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if user, pass, ok := gotReq.BasicAuth(); !ok || user != "dozer" || pass != "secret" {
+		t.Errorf("wanted basic auth got %s %s", user, pass)
+	}
+
+	// api key in header and query
+	hook.Auth = &Auth{Type: AUTH_API_KEY, Name: "X-Api-Key", Key: "secret"}
+	hook.Auth.compile()
+	if err := deliver(ctx, nil, nil, hook); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if gotReq.Header.Get("X-Api-Key") != "secret" {
+		t.Errorf("wanted api key header got %v", gotReq.Header)
+	}
+
+	hook.URL = server.URL + "?id=1"
+	hook.Auth = &Auth{Type: AUTH_API_KEY, Name: "api_key", Key: "secret", In: "query"}
+	if err := deliver(ctx, nil, nil, hook); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if gotReq.URL.Query().Get("api_key") != "secret" || gotReq.URL.Query().Get("id") != "1" {
+		t.Errorf("wanted api key query got %s", gotReq.URL.RawQuery)
+	}
+}
+
+func TestAuthOAuth2(t *testing.T) {
+	ctx := context.Background()
+
+	var fetched int
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		id, secret, _ := req.BasicAuth()
+		req.ParseForm()
+		if id != "dozer" || secret != "secret" || req.Form.Get("grant_type") != "client_credentials" || req.Form.Get("scope") != "hooks.write audit" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fetched++
+		fmt.Fprintf(rw, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 3600}`, fetched)
+	}))
+	defer tokenServer.Close()
+
+	// the webhook only accepts the latest token, as if earlier ones were revoked
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%d", fetched) {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Write([]byte(`ok`))
+	}))
+	defer server.Close()
+
+	auth := &Auth{Type: AUTH_OAUTH2, TokenURL: tokenServer.URL, ClientId: "dozer", ClientSecret: "secret", Scopes: []string{"hooks.write", "audit"}}
+	hook := &Hook{Description: "test hook", URL: server.URL, Method: "GET", Auth: auth}
+	defer auth.invalidate()
+
+	// the token is fetched once and cached
+	for i := 0; i < 3; i++ {
+		if err := deliver(ctx, nil, nil, hook); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if fetched != 1 || requests != 3 {
+		t.Errorf("wanted 1 token fetched for 3 requests got %d for %d", fetched, requests)
+	}
+
+	// the cached token is refused, a new one is fetched and the request made once more
+	fetched++
+	requests = 0
+	if err := deliver(ctx, nil, nil, hook); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if fetched != 3 || requests != 2 {
+		t.Errorf("wanted a new token and 2 requests got token %d and %d requests", fetched, requests)
+	}
+
+	// the token can not be fetched
+	auth.invalidate()
+	hook.Auth = &Auth{Type: AUTH_OAUTH2, TokenURL: tokenServer.URL, ClientId: "dozer", ClientSecret: "wrong"}
+	if err := deliver(ctx, nil, nil, hook); !errors.Is(err, ERR_AUTH_TOKEN) {
+		t.Errorf("wanted %v got %v", ERR_AUTH_TOKEN, err)
+	}
+}
+
+func TestAuthTokenLockedPerKey(t *testing.T) {
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+		rw.Write([]byte(`{"access_token": "slow", "expires_in": 3600}`))
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`{"access_token": "fast", "expires_in": 3600}`))
+	}))
+	defer fast.Close()
+
+	slowAuth := &Auth{Type: AUTH_OAUTH2, TokenURL: slow.URL, ClientId: "dozer", ClientSecret: "secret"}
+	fastAuth := &Auth{Type: AUTH_OAUTH2, TokenURL: fast.URL, ClientId: "dozer", ClientSecret: "secret"}
+	defer slowAuth.invalidate()
+	defer fastAuth.invalidate()
+	defer close(release)
+
+	go slowAuth.token(ctx, http.DefaultClient)
+
+	// the token of another url is fetched while the slow one is outstanding
+	got := make(chan string, 1)
+	go func() {
+		token, _ := fastAuth.token(ctx, http.DefaultClient)
+		got <- token
+	}()
+
+	select {
+	case token := <-got:
+		if token != "fast" {
+			t.Errorf("wanted fast token got '%s'", token)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("wanted token fetched while another token url is slow")
+	}
+}
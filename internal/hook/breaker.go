@@ -0,0 +1,178 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/koan"
+)
+
+// circuit breaker settings, they can be changed by the application before webhooks are sent
+var (
+	BreakerFailures = internal.BREAKER_FAILURES
+	BreakerCooldown = internal.BREAKER_COOLDOWN * time.Second
+)
+
+// circuit breaker states
+const (
+	BREAKER_CLOSED    = "closed"
+	BREAKER_OPEN      = "open"
+	BREAKER_HALF_OPEN = "half-open"
+)
+
+// breakers are keyed by the host of the webhook url
+var (
+	breakers   = map[string]*breaker{}
+	breakersMu sync.Mutex
+)
+
+// breaker is the circuit breaker of a host. It opens after consecutive failed requests so no more are
+// made until the cooldown has passed, then lets a single request through to probe the host. The breaker
+// closes if the probe succeeds and opens again if it fails
+type breaker struct {
+	host     string
+	state    string
+	failures int
+	opened   time.Time
+	mu       sync.Mutex
+}
+
+// BreakerStatus is the state of the circuit breaker of a host
+type BreakerStatus struct {
+	Host     string    `json:"host"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	Opened   time.Time `json:"opened,omitempty"`
+}
+
+// Breakers returns the state of the circuit breakers of the hosts requests have been made to
+func Breakers() []BreakerStatus {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for _, b := range breakers {
+		b.mu.Lock()
+		statuses = append(statuses, BreakerStatus{Host: b.host, State: b.state, Failures: b.failures, Opened: b.opened})
+		b.mu.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Host < statuses[j].Host
+	})
+	return statuses
+}
+
+// breakerFor returns the circuit breaker of the host of the webhook url
+func breakerFor(webhookURL string) *breaker {
+	host := webhookURL
+	if u, err := url.Parse(webhookURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[host]
+	if !ok {
+		b = &breaker{host: host, state: BREAKER_CLOSED}
+		breakers[host] = b
+	}
+	return b
+}
+
+// blocked checks if requests to the host are being held, without taking the probe of a half open breaker
+func (b *breaker) blocked() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BREAKER_OPEN:
+		return time.Since(b.opened) < BreakerCooldown
+	case BREAKER_HALF_OPEN:
+		return true
+	}
+	return false
+}
+
+// allow checks if a request can be made to the host, once the cooldown has passed an open breaker is
+// half opened and the caller makes the probe request
+func (b *breaker) allow(logger *koan.Logger) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BREAKER_OPEN:
+		if time.Since(b.opened) < BreakerCooldown {
+			return false
+		}
+		b.setState(BREAKER_HALF_OPEN, logger)
+		return true
+	case BREAKER_HALF_OPEN:
+		// the probe is in flight
+		return false
+	}
+	return true
+}
+
+// record updates the breaker with the result of a request, failures to respond or server errors count
+// against the host, other responses show it is up
+func (b *breaker) record(err error, logger *koan.Logger) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// a cancelled request or one not made for want of an access token says nothing of the host, the
+	// probe is made again
+	if errors.Is(err, context.Canceled) || errors.Is(err, ERR_AUTH_TOKEN) {
+		if b.state == BREAKER_HALF_OPEN {
+			b.state = BREAKER_OPEN
+		}
+		return
+	}
+
+	if !hostDown(err) {
+		b.failures = 0
+		if b.state != BREAKER_CLOSED {
+			b.setState(BREAKER_CLOSED, logger)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == BREAKER_HALF_OPEN || (b.state == BREAKER_CLOSED && b.failures >= BreakerFailures) {
+		b.opened = time.Now()
+		b.setState(BREAKER_OPEN, logger)
+	}
+}
+
+// setState changes the state of the breaker and logs it, the lock must be held
+func (b *breaker) setState(state string, logger *koan.Logger) {
+	b.state = state
+	msg := fmt.Sprintf("Circuit breaker %s (host: '%s', consecutive failures: %d)", state, b.host, b.failures)
+	if state == BREAKER_OPEN {
+		logger.Warn(msg)
+		return
+	}
+	logger.Info(msg)
+}
+
+// hostDown checks if the error shows the host is not able to handle requests, failing to get an access
+// token is a problem with the token url not the host
+func hostDown(err error) bool {
+	if err == nil || errors.Is(err, ERR_AUTH_TOKEN) {
+		return false
+	}
+
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.statusCode >= 500
+	}
+
+	var ae *assertionError
+	return !errors.As(err, &ae)
+}
@@ -0,0 +1,114 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/koan"
+)
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+	logger := &koan.Logger{}
+
+	failures, cooldown := BreakerFailures, BreakerCooldown
+	BreakerFailures, BreakerCooldown = 2, 50*time.Millisecond
+	defer func() { BreakerFailures, BreakerCooldown = failures, cooldown }()
+
+	status := http.StatusServiceUnavailable
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		rw.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	hook := &Hook{Description: "test hook", URL: server.URL + "/path", Method: "GET"}
+	b := breakerFor(server.URL)
+
+	// client errors show the host is up
+	status = http.StatusNotFound
+	for i := 0; i < 3; i++ {
+		deliverWithRetry(ctx, nil, nil, hook, logger)
+	}
+	if b.state != BREAKER_CLOSED {
+		t.Fatalf("wanted %s got %s", BREAKER_CLOSED, b.state)
+	}
+
+	// failing to get an access token does not count against the host
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer tokenServer.Close()
+	hook.Auth = &Auth{Type: AUTH_OAUTH2, TokenURL: tokenServer.URL, ClientId: "dozer", ClientSecret: "secret"}
+	requests = 0
+	for i := 0; i < 3; i++ {
+		if _, err := deliverWithRetry(ctx, nil, nil, hook, logger); !errors.Is(err, ERR_AUTH_TOKEN) {
+			t.Fatalf("wanted %v got %v", ERR_AUTH_TOKEN, err)
+		}
+	}
+	if b.state != BREAKER_CLOSED || b.failures != 0 || requests != 0 {
+		t.Fatalf("wanted %s and no requests got %s with %d failures and %d requests", BREAKER_CLOSED, b.state, b.failures, requests)
+	}
+
+	// nor does a token url which is down
+	tokenServer.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := deliverWithRetry(ctx, nil, nil, hook, logger); !errors.Is(err, ERR_AUTH_TOKEN) {
+			t.Fatalf("wanted %v got %v", ERR_AUTH_TOKEN, err)
+		}
+	}
+	if b.state != BREAKER_CLOSED || b.failures != 0 || requests != 0 {
+		t.Fatalf("wanted %s and no requests got %s with %d failures and %d requests", BREAKER_CLOSED, b.state, b.failures, requests)
+	}
+	hook.Auth = nil
+
+	// consecutive server errors open the breaker
+	status = http.StatusServiceUnavailable
+	deliverWithRetry(ctx, nil, nil, hook, logger)
+	deliverWithRetry(ctx, nil, nil, hook, logger)
+	if b.state != BREAKER_OPEN || !b.blocked() {
+		t.Fatalf("wanted %s got %s", BREAKER_OPEN, b.state)
+	}
+
+	// no request is made while open
+	requests = 0
+	if _, err := deliverWithRetry(ctx, nil, nil, hook, logger); !errors.Is(err, ERR_CIRCUIT_OPEN) || requests != 0 {
+		t.Errorf("wanted %v and no request got %v and %d requests", ERR_CIRCUIT_OPEN, err, requests)
+	}
+
+	// the probe fails and the breaker opens again
+	time.Sleep(BreakerCooldown)
+	if b.blocked() {
+		t.Errorf("wanted breaker ready to probe")
+	}
+	deliverWithRetry(ctx, nil, nil, hook, logger)
+	if b.state != BREAKER_OPEN || requests != 1 {
+		t.Fatalf("wanted %s after a single probe got %s and %d requests", BREAKER_OPEN, b.state, requests)
+	}
+
+	// the probe succeeds and the breaker closes
+	time.Sleep(BreakerCooldown)
+	status = http.StatusOK
+	if _, err := deliverWithRetry(ctx, nil, nil, hook, logger); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if b.state != BREAKER_CLOSED || b.failures != 0 {
+		t.Errorf("wanted %s got %s with %d failures", BREAKER_CLOSED, b.state, b.failures)
+	}
+
+	statuses := Breakers()
+	var found bool
+	for _, s := range statuses {
+		if s.Host == b.host && s.State == BREAKER_CLOSED {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("wanted breaker for %s in %v", b.host, statuses)
+	}
+}
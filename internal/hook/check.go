@@ -5,298 +5,221 @@ import (
 	"fmt"
 
 	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
 	"github.com/spoonboy-io/koan"
 )
 
-// CheckProcess will check a process against the configuration to determine if
-// it is an event that should trigger a call webhook
-func CheckProcess(ctx context.Context, process *internal.Process, logger *koan.Logger) {
+// process lifecycle events which hooks can subscribe to, available in the templates as {{.Event}}
+const (
+	EVENT_STARTED   = "started"
+	EVENT_PROGRESS  = "progress"
+	EVENT_COMPLETED = "completed"
+	EVENT_FAILED    = "failed"
+	EVENT_STUCK     = "stuck"
+	EVENT_ABSENT    = "absent"
+	EVENT_RECOVERED = "recovered"
+)
+
+// CheckProcess will check a process which has finished against the configuration to determine if
+// it is an event that should trigger a call webhook, the event is completed or failed based on the status
+func CheckProcess(ctx context.Context, process *internal.Process, st *state.State, logger *koan.Logger) {
+	checkEvent(ctx, finishedEvent(process), process, 0, st, logger)
+}
+
+// CheckStarted will check a process the first time it is seen executing against hooks
+// subscribed to the started event
+func CheckStarted(ctx context.Context, process *internal.Process, st *state.State, logger *koan.Logger) {
+	checkEvent(ctx, EVENT_STARTED, process, 0, st, logger)
+}
+
+// CheckProgress will check an executing process against hooks subscribed to the progress event,
+// hooks fire when the process percent has crossed one of their thresholds since lastPercent
+func CheckProgress(ctx context.Context, process *internal.Process, lastPercent float64, st *state.State, logger *koan.Logger) {
+	checkEvent(ctx, EVENT_PROGRESS, process, lastPercent, st, logger)
+}
+
+func checkEvent(ctx context.Context, event string, process *internal.Process, lastPercent float64, st *state.State, logger *koan.Logger) {
+	sp := newSafeProcess(process)
+
 	// go through all the hook config
 	for i := range config {
-		// config uses code we need to search on name, we swap the code for the name in the config
-		processName, err := getProcessTypeName(config[i].Triggers.ProcessType)
-		if err == nil {
-			config[i].Triggers.ProcessType = processName
+		// stuck hooks only fire for processes they have seen executing, see CheckStuck, and
+		// aggregating hooks once for a workflow, see SendWorkflows
+		if config[i].stuckAfter != 0 || config[i].Aggregate != "" {
+			continue
 		}
 
-		if fire := checkStatus(process, &config[i].Hook); fire {
-			if err := fireWebhook(ctx, process, &config[i].Hook); err != nil {
-				warnMsg := fmt.Sprintf("Failed to fire webhook on status (hook: '%s', url: '%s', process id: '%d') error: %v",
-					config[i].Hook.Description, config[i].Hook.URL, process.Id, err)
-				logger.Warn(warnMsg)
-			}
+		// correlating hooks see every finished process to record its status
+		if !config[i].subscribes(event) && !config[i].correlates(event) {
 			continue
 		}
 
-		if fire := checkProcessType(process, &config[i].Hook); fire {
-			if err := fireWebhook(ctx, process, &config[i].Hook); err != nil {
-				warnMsg := fmt.Sprintf("Failed to fire webhook on procesType (hook: '%s', url: '%s', process id: '%d') error: %v",
-					config[i].Hook.Description, config[i].Hook.URL, process.Id, err)
-				logger.Warn(warnMsg)
-			}
+		if event == EVENT_PROGRESS && !config[i].crossesProgress(lastPercent, sp.Percent) {
 			continue
 		}
 
-		if fire := checkTaskName(process, &config[i].Hook); fire {
-			if err := fireWebhook(ctx, process, &config[i].Hook); err != nil {
-				warnMsg := fmt.Sprintf("Failed to fire webhook on taskName (hook: '%s', url: '%s', process id: '%d') error: %v",
-					config[i].Hook.Description, config[i].Hook.URL, process.Id, err)
-				logger.Warn(warnMsg)
-			}
+		data := &templateData{safeProcess: sp, Event: event, Matches: map[string][]string{}}
+		fire, err := shouldFire(data, &config[i].Hook)
+		if err != nil {
+			warnMsg := fmt.Sprintf("Failed to evaluate when expression (hook: '%s', process id: '%d') error: %v",
+				config[i].Hook.Description, process.Id, err)
+			logger.Warn(warnMsg)
 			continue
 		}
 
-		if fire := checkAccountId(process, &config[i].Hook); fire {
-			if err := fireWebhook(ctx, process, &config[i].Hook); err != nil {
-				warnMsg := fmt.Sprintf("Failed to fire webhook on accountId (hook: '%s', url: '%s', process id: '%d') error: %v",
-					config[i].Hook.Description, config[i].Hook.URL, process.Id, err)
-				logger.Warn(warnMsg)
-			}
-			continue
+		// the event is recovered if the last process with the same correlation failed
+		if fire && config[i].correlates(event) {
+			data.Event = config[i].correlate(data, st)
 		}
 
-		if fire := checkCreatedBy(process, &config[i].Hook); fire {
-			if err := fireWebhook(ctx, process, &config[i].Hook); err != nil {
-				warnMsg := fmt.Sprintf("Failed to fire webhook on createdBy (hook: '%s', url: '%s', process id: '%d') error: %v",
-					config[i].Hook.Description, config[i].Hook.URL, process.Id, err)
-				logger.Warn(warnMsg)
-			}
+		if !config[i].subscribes(data.Event) {
 			continue
 		}
-	}
-}
 
-func checkStatus(process *internal.Process, hook *Hook) bool {
-	var fire bool
-	if process.Status == "" {
-		return fire
-	}
-	if process.Status == "executing" {
-		// we should never be in here as processes with executing status
-		// should not be passed for inspection, we monitor until done/failed
-		return fire
-	}
-	if hook.Triggers.Status == process.Status {
-		fire = true
-		if hook.Triggers.ProcessType != "" {
-			fire = false
-			if hook.Triggers.ProcessType == process.ProcessTypeName.String {
-				fire = true
-			} else {
-				return fire
-			}
+		// absence hooks record matching processes and fire when they are not seen, see CheckAbsence
+		if fire && config[i].absentAfter != 0 {
+			config[i].seen(data, st)
+			continue
 		}
-		if hook.Triggers.TaskName != "" {
-			fire = false
-			if hook.Triggers.TaskName == process.TaskName.String {
-				fire = true
-			} else {
-				return fire
-			}
+
+		// threshold hooks fire only once enough matching processes have been seen
+		if fire && config[i].Threshold != nil {
+			fire = config[i].reachesThreshold(data, st)
 		}
 
-		if hook.Triggers.AccountId != 0 {
-			fire = false
-			if hook.Triggers.AccountId == int(process.AccountId.Int64) {
-				fire = true
-			} else {
-				return fire
+		// repeated events are dropped while the suppression window is open
+		if fire && config[i].Suppress != nil {
+			suppressed, err := config[i].suppressed(data, process, st)
+			if err != nil {
+				warnMsg := fmt.Sprintf("Failed to render suppress key (hook: '%s', process id: '%d') error: %v",
+					config[i].Hook.Description, process.Id, err)
+				logger.Warn(warnMsg)
 			}
+			fire = !suppressed
 		}
 
-		if hook.Triggers.CreatedBy != "" {
-			fire = false
-			if hook.Triggers.CreatedBy == process.CreatedBy.String {
-				fire = true
-			} else {
-				return fire
-			}
+		if fire {
+			sendWebhook(ctx, data, &config[i].Hook, logger)
 		}
 	}
-
-	return fire
 }
 
-func checkProcessType(process *internal.Process, hook *Hook) bool {
-	var fire bool
-	if process.ProcessTypeName.String == "" {
-		return fire
+// finishedEvent returns the event for a process which is no longer executing
+func finishedEvent(process *internal.Process) string {
+	if process.Status == "failed" {
+		return EVENT_FAILED
 	}
-	if hook.Triggers.ProcessType == process.ProcessTypeName.String {
-		fire = true
-		if hook.Triggers.Status != "" {
-			fire = false
-			if hook.Triggers.Status == process.Status {
-				fire = true
-			} else {
-				return fire
-			}
-		}
-		if hook.Triggers.TaskName != "" {
-			fire = false
-			if hook.Triggers.TaskName == process.TaskName.String {
-				fire = true
-			} else {
-				return fire
-			}
-		}
-
-		if hook.Triggers.AccountId != 0 {
-			fire = false
-			if hook.Triggers.AccountId == int(process.AccountId.Int64) {
-				fire = true
-			} else {
-				return fire
-			}
-		}
+	return EVENT_COMPLETED
+}
 
-		if hook.Triggers.CreatedBy != "" {
-			fire = false
-			if hook.Triggers.CreatedBy == process.CreatedBy.String {
-				fire = true
-			} else {
-				return fire
-			}
+// subscribes checks if the hook fires on the event, hooks without events fire
+// when processes complete or fail
+func (h *Hook) subscribes(event string) bool {
+	if len(h.Events) == 0 {
+		return event == EVENT_COMPLETED || event == EVENT_FAILED
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
 		}
 	}
+	return false
+}
 
-	return fire
+// correlates checks if the hook records the status of finished processes to fire the recovered event
+func (h *Hook) correlates(event string) bool {
+	return len(h.correlateBy) > 0 && (event == EVENT_COMPLETED || event == EVENT_FAILED)
 }
 
-func checkTaskName(process *internal.Process, hook *Hook) bool {
-	var fire bool
-	if process.TaskName.String == "" {
-		return fire
+// correlate records the status of the process against its correlation key, returning the
+// recovered event if the process completed and the last process with the same key failed
+func (h *Hook) correlate(data *templateData, st *state.State) string {
+	key := variablesKey(h.Description, h.CorrelateBy, h.correlateBy, &data.safeProcess)
+	last := st.SetStatus(key, data.Status)
+	if data.Event == EVENT_COMPLETED && last == "failed" {
+		return EVENT_RECOVERED
 	}
-	if hook.Triggers.TaskName == process.TaskName.String {
-		fire = true
-		if hook.Triggers.Status != "" {
-			fire = false
-			if hook.Triggers.Status == process.Status {
-				fire = true
-			} else {
-				return fire
-			}
-		}
-		if hook.Triggers.ProcessType != "" {
-			fire = false
-			if hook.Triggers.ProcessType == process.ProcessTypeName.String {
-				fire = true
-			} else {
-				return fire
-			}
-		}
-
-		if hook.Triggers.AccountId != 0 {
-			fire = false
-			if hook.Triggers.AccountId == int(process.AccountId.Int64) {
-				fire = true
-			} else {
-				return fire
-			}
-		}
+	return data.Event
+}
 
-		if hook.Triggers.CreatedBy != "" {
-			fire = false
-			if hook.Triggers.CreatedBy == process.CreatedBy.String {
-				fire = true
-			} else {
-				return fire
-			}
+// crossesProgress checks if any of the hook's progress thresholds lie between the two percentages
+func (h *Hook) crossesProgress(lastPercent, percent float64) bool {
+	for _, threshold := range h.Progress {
+		if lastPercent < threshold && percent >= threshold {
+			return true
 		}
 	}
-
-	return fire
+	return false
 }
 
-func checkAccountId(process *internal.Process, hook *Hook) bool {
-	var fire bool
-	if int(process.AccountId.Int64) == 0 {
-		return fire
+// sendWebhook renders the body and headers and queues the webhook for delivery, see ProcessQueue. Without a queue, or if
+// it cannot be written, the webhook is delivered now, retrying as set by the hook's retry policy. Failures are
+// logged and, once all attempts have been made, added to the dead letter store
+func sendWebhook(ctx context.Context, data *templateData, hook *Hook, logger *koan.Logger) {
+	body, err := renderBody(data, hook)
+	if err != nil {
+		warnMsg := fmt.Sprintf("Failed to parse request body (hook: '%s', process id: '%d') error: %v",
+			hook.Description, data.Id, err)
+		logger.Warn(warnMsg)
+		return
 	}
-	if hook.Triggers.AccountId == int(process.AccountId.Int64) {
-		fire = true
-		if hook.Triggers.ProcessType != "" {
-			fire = false
-			if hook.Triggers.ProcessType == process.ProcessTypeName.String {
-				fire = true
-			} else {
-				return fire
-			}
-		}
-		if hook.Triggers.TaskName != "" {
-			fire = false
-			if hook.Triggers.TaskName == process.TaskName.String {
-				fire = true
-			} else {
-				return fire
-			}
-		}
 
-		if hook.Triggers.Status != "" {
-			fire = false
-			if hook.Triggers.Status == process.Status {
-				fire = true
-			} else {
-				return fire
-			}
+	headers, err := renderHeaders(data, hook)
+	if err != nil {
+		warnMsg := fmt.Sprintf("Failed to parse headers (hook: '%s', process id: '%d') error: %v",
+			hook.Description, data.Id, err)
+		logger.Warn(warnMsg)
+		return
+	}
+
+	if Queue != nil {
+		// wait for deliveries to be made when the queue is full, which holds up the poll
+		if Queue.Full(QueueLimit) {
+			logger.Warn(fmt.Sprintf("Delivery queue is full, waiting to queue webhook (hook: '%s', process id: '%d')",
+				hook.Description, data.Id))
+			Queue.WaitForRoom(ctx, QueueLimit)
 		}
 
-		if hook.Triggers.CreatedBy != "" {
-			fire = false
-			if hook.Triggers.CreatedBy == process.CreatedBy.String {
-				fire = true
-			} else {
-				return fire
-			}
+		err := enqueue(data, body, headers, hook)
+		if err == nil {
+			return
 		}
+		logger.Error("Failed to queue webhook, delivering now", err)
 	}
 
-	return fire
+	attempts, err := deliverWithRetry(ctx, body, headers, hook, logger)
+	if err != nil {
+		warnMsg := fmt.Sprintf("Failed to fire webhook (hook: '%s', url: '%s', process id: '%d', attempts: %d) error: %v",
+			hook.Description, hook.URL, data.Id, attempts, err)
+		logger.Warn(warnMsg)
+		deadLetter(hook, data.Id, data.Event, body, headers, attempts, err, logger)
+	}
 }
 
-func checkCreatedBy(process *internal.Process, hook *Hook) bool {
-	var fire bool
-	if process.CreatedBy.String == "" {
-		return fire
+// shouldFire determines if the hook should fire for the process, all triggers set on the hook
+// must match and, if the hook has a `when` expression, it must evaluate true. Capture groups
+// of regex triggers are added to the template data, empty for those which did not match
+func shouldFire(data *templateData, hook *Hook) (bool, error) {
+	if !matchTriggers(&data.safeProcess, hook.matcher, data.Matches) {
+		return false, nil
 	}
-	if hook.Triggers.CreatedBy == process.CreatedBy.String {
-		fire = true
-		if hook.Triggers.ProcessType != "" {
-			fire = false
-			if hook.Triggers.ProcessType == process.ProcessTypeName.String {
-				fire = true
-			} else {
-				return fire
-			}
-		}
-		if hook.Triggers.TaskName != "" {
-			fire = false
-			if hook.Triggers.TaskName == process.TaskName.String {
-				fire = true
-			} else {
-				return fire
-			}
-		}
+	fillMatches(data.Matches, hook.groups)
 
-		if hook.Triggers.AccountId != 0 {
-			fire = false
-			if hook.Triggers.AccountId == int(process.AccountId.Int64) {
-				fire = true
-			} else {
-				return fire
-			}
-		}
+	if hook.program == nil {
+		return true, nil
+	}
 
-		if hook.Triggers.Status != "" {
-			fire = false
-			if hook.Triggers.Status == process.Status {
-				fire = true
-			} else {
-				return fire
-			}
-		}
+	return evalWhen(hook.program, &data.safeProcess)
+}
+
+// matchTriggers checks each trigger which has been set against the process, they are additive
+// so any trigger which does not match the process prevents the hook firing
+func matchTriggers(sp *safeProcess, matcher triggerSet, matches map[string][]string) bool {
+	if sp.Status == "executing" {
+		// we should never be in here as processes with executing status
+		// should not be passed for inspection, we monitor until done/failed
+		return false
 	}
 
-	return fire
+	return matcher.match(sp, matches)
 }
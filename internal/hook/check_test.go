@@ -1,25 +1,45 @@
 package hook
 
 import (
+	"context"
 	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
+	"github.com/spoonboy-io/koan"
 )
 
 // TestCheckProcessLogic contains test cases that checks that hooks fire correctly based on
 // the hook configuration when test processes are inspected. We are not testing the webhook call itself here,
 // we are whitebox testing as we are calling unexported functions from the package
 func TestCheckProcessesLogic(t *testing.T) {
+	internal.ProcessTypes = map[string]string{
+		"localWorkflow": "Local Workflow",
+	}
+	defer func() { internal.ProcessTypes = nil }()
+
+	internal.SetAccounts(map[int64]internal.Account{
+		2: {Id: 2, Name: sql.NullString{String: "Acme", Valid: true}},
+	})
+	internal.SetUsers(map[int64]internal.User{
+		7: {Id: 7, Email: sql.NullString{String: "ops@acme.com", Valid: true}},
+	})
+	defer func() {
+		internal.SetAccounts(nil)
+		internal.SetUsers(nil)
+	}()
+
 	testCases := []struct {
-		name                string
-		process             internal.Process
-		hook                Hook
-		wantFireStatus      bool
-		wantFireProcessType bool
-		wantFireTaskName    bool
-		wantFireAccountId   bool
-		wantFireCreatedBy   bool
+		name        string
+		process     internal.Process
+		hook        Hook
+		wantFire    bool
+		wantMatches map[string][]string
 	}{
 		// status alone
 		{
@@ -29,10 +49,10 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					Status: "complete",
+					"status": "complete",
 				},
 			},
-			wantFireStatus: true,
+			wantFire: true,
 		},
 		{
 			name: "Will not fire on status complete",
@@ -41,22 +61,24 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					Status: "failed",
+					"status": "failed",
 				},
 			},
-			wantFireStatus: false,
+			wantFire: false,
 		},
 		{
 			name: "Will not fire on status executing",
 			process: internal.Process{
-				Status: "executing",
+				Status:   "executing",
+				TaskName: sql.NullString{String: "Test Task"},
 			},
 			hook: Hook{
+				// a status trigger of executing is rejected by ValidateConfig
 				Triggers: Trigger{
-					Status: "executing",
+					"taskName": "Test Task",
 				},
 			},
-			wantFireStatus: false,
+			wantFire: false,
 		},
 
 		// processType alone
@@ -67,11 +89,11 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					// once config is process hook contains the name, not the code
-					ProcessType: "local workflow",
+					// not a known process type code so compared as the name
+					"processType": "local workflow",
 				},
 			},
-			wantFireProcessType: true,
+			wantFire: true,
 		},
 		{
 			name: "Trigger for 'reconfigure' does not fire processTypeName 'local workflow' ",
@@ -80,10 +102,10 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					ProcessType: "reconfigure",
+					"processType": "reconfigure",
 				},
 			},
-			wantFireProcessType: false,
+			wantFire: false,
 		},
 
 		// taskName alone
@@ -94,10 +116,10 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					TaskName: "Test Task",
+					"taskName": "Test Task",
 				},
 			},
-			wantFireTaskName: true,
+			wantFire: true,
 		},
 		{
 			name: "Does not fire for taskName 'Test task' trigger is for different task",
@@ -106,10 +128,10 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					TaskName: "Test Task With Another name",
+					"taskName": "Test Task With Another name",
 				},
 			},
-			wantFireTaskName: false,
+			wantFire: false,
 		},
 
 		// AccountId alone
@@ -120,10 +142,10 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					AccountId: 1,
+					"accountId": 1,
 				},
 			},
-			wantFireAccountId: true,
+			wantFire: true,
 		},
 		{
 			name: "Does not fire for AccountId '1' trigger is looking for tenant with id '2'",
@@ -132,10 +154,10 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					AccountId: 2,
+					"accountId": 2,
 				},
 			},
-			wantFireAccountId: false,
+			wantFire: false,
 		},
 
 		// CreatedBy alone
@@ -146,10 +168,10 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					CreatedBy: "Testuser",
+					"createdBy": "Testuser",
 				},
 			},
-			wantFireCreatedBy: true,
+			wantFire: true,
 		},
 		{
 			name: "Does not fire for created by 'Testuser' trigger is looking for 'admin'",
@@ -158,10 +180,10 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					CreatedBy: "admin",
+					"createdBy": "admin",
 				},
 			},
-			wantFireCreatedBy: false,
+			wantFire: false,
 		},
 
 		// Combination additive test cases from here to cover remaining function logic
@@ -172,11 +194,11 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					Status:    "complete",
-					AccountId: 2,
+					"status":    "complete",
+					"accountId": 2,
 				},
 			},
-			wantFireStatus: false,
+			wantFire: false,
 		},
 		{
 			name: "Should not fire, requires additional matches 1",
@@ -187,12 +209,12 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					Status:      "complete",
-					ProcessType: "reconfigure",
-					AccountId:   2,
+					"status":      "complete",
+					"processType": "reconfigure",
+					"accountId":   2,
 				},
 			},
-			wantFireStatus: false,
+			wantFire: false,
 		},
 		{
 			name: "Should not fire, requires additional matches 3",
@@ -204,13 +226,13 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					Status:      "complete",
-					ProcessType: "Test Process Name",
-					TaskName:    "Different Task Name",
-					AccountId:   2,
+					"status":      "complete",
+					"processType": "Test Process Name",
+					"taskName":    "Different Task Name",
+					"accountId":   2,
 				},
 			},
-			wantFireStatus: false,
+			wantFire: false,
 		},
 		{
 			name: "Should not fire, requires additional matches 4",
@@ -223,14 +245,14 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					Status:      "complete",
-					ProcessType: "Test Process Name",
-					TaskName:    "Test Task Name",
-					CreatedBy:   "Admin",
-					AccountId:   2,
+					"status":      "complete",
+					"processType": "Test Process Name",
+					"taskName":    "Test Task Name",
+					"createdBy":   "Admin",
+					"accountId":   2,
 				},
 			},
-			wantFireStatus: false,
+			wantFire: false,
 		},
 		{
 			name: "Should fire, all triggers match",
@@ -243,56 +265,740 @@ func TestCheckProcessesLogic(t *testing.T) {
 			},
 			hook: Hook{
 				Triggers: Trigger{
-					Status:      "complete",
-					ProcessType: "Test Process Name",
-					TaskName:    "Test Task Name",
-					CreatedBy:   "Test User",
-					AccountId:   2,
+					"status":      "complete",
+					"processType": "Test Process Name",
+					"taskName":    "Test Task Name",
+					"createdBy":   "Test User",
+					"accountId":   2,
+				},
+			},
+			wantFire: true,
+		},
+
+		// generic triggers on any process variable
+		{
+			name: "Should fire, zoneId and instanceName match",
+			process: internal.Process{
+				Status:       "complete",
+				ZoneId:       sql.NullInt64{Int64: 3},
+				InstanceName: sql.NullString{String: "web-01"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"zoneId":       3,
+					"instanceName": "web-01",
+				},
+			},
+			wantFire: true,
+		},
+		{
+			name: "Should not fire, serverGroupName does not match",
+			process: internal.Process{
+				Status:          "complete",
+				JobTemplateName: sql.NullString{String: "nightly"},
+				ServerGroupName: sql.NullString{String: "vm"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"jobTemplateName": "nightly",
+					"serverGroupName": "k8s",
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should fire, process type code is swapped for name",
+			process: internal.Process{
+				ProcessTypeName: sql.NullString{String: "Local Workflow"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"processType": "localWorkflow",
+				},
+			},
+			wantFire: true,
+		},
+
+		// multi-value, negated and grouped triggers
+		{
+			name: "Should fire, accountId is in list and createdBy is not excluded",
+			process: internal.Process{
+				Status:    "failed",
+				AccountId: sql.NullInt64{Int64: 5},
+				CreatedBy: sql.NullString{String: "admin"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status":    "failed",
+					"accountId": []interface{}{2, 5, 9},
+					"not": Trigger{
+						"createdBy": "svc-ci",
+					},
+				},
+			},
+			wantFire: true,
+		},
+		{
+			name: "Should not fire, accountId is not in list",
+			process: internal.Process{
+				Status:    "failed",
+				AccountId: sql.NullInt64{Int64: 3},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status":    "failed",
+					"accountId": []interface{}{2, 5, 9},
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should not fire, createdBy is excluded",
+			process: internal.Process{
+				Status:    "failed",
+				AccountId: sql.NullInt64{Int64: 5},
+				CreatedBy: sql.NullString{String: "svc-cd"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status":    "failed",
+					"accountId": []interface{}{2, 5, 9},
+					"not": Trigger{
+						"createdBy": []interface{}{"svc-ci", "svc-cd"},
+					},
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should fire, second anyOf group matches",
+			process: internal.Process{
+				Status:    "failed",
+				AccountId: sql.NullInt64{Int64: 7},
+				TaskName:  sql.NullString{String: "Backup"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status": "failed",
+					"anyOf": []interface{}{
+						Trigger{"accountId": 2},
+						Trigger{"taskName": "Backup", "not": Trigger{"accountId": 1}},
+					},
+				},
+			},
+			wantFire: true,
+		},
+		{
+			name: "Should not fire, no anyOf group matches",
+			process: internal.Process{
+				Status:    "failed",
+				AccountId: sql.NullInt64{Int64: 1},
+				TaskName:  sql.NullString{String: "Backup"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status": "failed",
+					"anyOf": []interface{}{
+						Trigger{"accountId": 2},
+						Trigger{"taskName": "Backup", "not": Trigger{"accountId": 1}},
+					},
+				},
+			},
+			wantFire: false,
+		},
+
+		// regex and glob triggers
+		{
+			name: "Should fire, taskName matches regex and instanceName matches glob",
+			process: internal.Process{
+				Status:       "complete",
+				TaskName:     sql.NullString{String: "Deploy App v12"},
+				InstanceName: sql.NullString{String: "prod-web-01"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"taskName":     map[interface{}]interface{}{"regex": `^Deploy App v(\d+)$`},
+					"instanceName": map[interface{}]interface{}{"glob": "prod-*"},
+				},
+			},
+			wantFire:    true,
+			wantMatches: map[string][]string{"taskName": {"Deploy App v12", "12"}},
+		},
+		{
+			name: "Should fire, taskName matches the exact value listed with a regex, capture groups are empty",
+			process: internal.Process{
+				Status:   "complete",
+				TaskName: sql.NullString{String: "Exact"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"taskName": []interface{}{map[interface{}]interface{}{"regex": `^Deploy (\d+)$`}, "Exact"},
+				},
+			},
+			wantFire:    true,
+			wantMatches: map[string][]string{"taskName": {"", ""}},
+		},
+		{
+			name: "Should fire, anyOf matched without the regex branch, capture groups are empty",
+			process: internal.Process{
+				Status:    "complete",
+				AccountId: sql.NullInt64{Int64: 2},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"anyOf": []interface{}{
+						Trigger{"accountId": 2},
+						Trigger{"taskName": map[interface{}]interface{}{"regex": `^Deploy (\d+)-(\w+)$`}},
+					},
+				},
+			},
+			wantFire:    true,
+			wantMatches: map[string][]string{"taskName": {"", "", ""}},
+		},
+		{
+			name: "Should not fire, taskName does not match regex",
+			process: internal.Process{
+				Status:   "complete",
+				TaskName: sql.NullString{String: "Deploy App"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"taskName": map[interface{}]interface{}{"regex": `^Deploy App v(\d+)$`},
+				},
+			},
+			wantFire:    false,
+			wantMatches: map[string][]string{},
+		},
+		{
+			name: "Should not fire, instanceName excluded by glob",
+			process: internal.Process{
+				Status:       "complete",
+				InstanceName: sql.NullString{String: "prod-web-01"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status": "complete",
+					"not": Trigger{
+						"instanceName": map[interface{}]interface{}{"glob": "prod-*"},
+					},
+				},
+			},
+			wantFire:    false,
+			wantMatches: map[string][]string{},
+		},
+
+		// numeric comparisons
+		{
+			name: "Should fire, duration greater than threshold",
+			process: internal.Process{
+				Status:   "complete",
+				Duration: sql.NullInt64{Int64: 1200000},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"duration": map[interface{}]interface{}{"gt": 900000},
+				},
+			},
+			wantFire: true,
+		},
+		{
+			name: "Should not fire, duration equal to threshold",
+			process: internal.Process{
+				Status:   "complete",
+				Duration: sql.NullInt64{Int64: 900000},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"duration": map[interface{}]interface{}{"gt": 900000},
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should fire, complete with non-zero exit code",
+			process: internal.Process{
+				Status:   "complete",
+				ExitCode: sql.NullString{String: "2"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status":   "complete",
+					"exitCode": map[interface{}]interface{}{"ne": "0"},
+				},
+			},
+			wantFire: true,
+		},
+		{
+			name: "Should not fire, complete with zero exit code",
+			process: internal.Process{
+				Status:   "complete",
+				ExitCode: sql.NullString{String: "0"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status":   "complete",
+					"exitCode": map[interface{}]interface{}{"ne": "0"},
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should not fire, null exit code is not non-zero",
+			process: internal.Process{
+				Status: "complete",
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status":   "complete",
+					"exitCode": map[interface{}]interface{}{"ne": "0"},
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should not fire, exit code 00 is zero",
+			process: internal.Process{
+				Status:   "complete",
+				ExitCode: sql.NullString{String: "00"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status":   "complete",
+					"exitCode": map[interface{}]interface{}{"ne": 0},
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should fire, exit code compared as a number",
+			process: internal.Process{
+				Status:   "failed",
+				ExitCode: sql.NullString{String: "127"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"exitCode": map[interface{}]interface{}{"gte": 100},
+				},
+			},
+			wantFire: true,
+		},
+		{
+			name: "Should not fire, empty exit code can not be compared",
+			process: internal.Process{
+				Status: "failed",
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"exitCode": map[interface{}]interface{}{"lt": 100},
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should fire, percent within range",
+			process: internal.Process{
+				Status:  "failed",
+				Percent: sql.NullFloat64{Float64: 75.5},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"percent": map[interface{}]interface{}{"gte": 50, "lt": 100},
+				},
+			},
+			wantFire: true,
+		},
+
+		// content triggers
+		{
+			name: "Should fire, complete but output contains a warning",
+			process: internal.Process{
+				Status: "complete",
+				Output: sql.NullString{String: "step 1 ok\nWARNING: certificate expires soon\n"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status": "complete",
+					"output": map[interface{}]interface{}{"contains": "WARNING"},
+				},
+			},
+			wantFire: true,
+		},
+		{
+			name: "Should fire, message matches stack trace regex",
+			process: internal.Process{
+				Status:  "complete",
+				Message: sql.NullString{String: "Traceback (most recent call last):"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"anyOf": []interface{}{
+						Trigger{"error": map[interface{}]interface{}{"regex": "Exception"}},
+						Trigger{"message": map[interface{}]interface{}{"regex": "^Traceback"}},
+					},
+				},
+			},
+			wantFire: true,
+		},
+
+		// when expressions
+		{
+			name: "Should fire, when expression alone matches",
+			process: internal.Process{
+				Status:       "failed",
+				Duration:     sql.NullInt64{Int64: 900},
+				InstanceName: sql.NullString{String: "prod-web-01"},
+			},
+			hook: Hook{
+				When: `status == "failed" && duration > 600 && instanceName.startsWith("prod-")`,
+			},
+			wantFire: true,
+		},
+		{
+			name: "Should not fire, when expression alone does not match",
+			process: internal.Process{
+				Status:       "failed",
+				Duration:     sql.NullInt64{Int64: 300},
+				InstanceName: sql.NullString{String: "prod-web-01"},
+			},
+			hook: Hook{
+				When: `status == "failed" && duration > 600 && instanceName.startsWith("prod-")`,
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should not fire, triggers match but when expression does not",
+			process: internal.Process{
+				Status:    "complete",
+				AccountId: sql.NullInt64{Int64: 2},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status": "complete",
+				},
+				When: "accountId == 3",
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should not fire, when expression matches but triggers do not",
+			process: internal.Process{
+				Status:    "complete",
+				AccountId: sql.NullInt64{Int64: 3},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"status": "failed",
+				},
+				When: "accountId == 3",
+			},
+			wantFire: false,
+		},
+
+		// enrichment from instance, cloud and server
+		{
+			name: "Should fire, instance tag and cloud name match",
+			process: internal.Process{
+				Status:   "complete",
+				Instance: &internal.Instance{Tags: map[string]string{"env": "prod"}},
+				Cloud:    &internal.Cloud{Name: "AWS"},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"instanceTags.env": "prod",
+					"cloud.name":       "AWS",
+				},
+			},
+			wantFire: true,
+		},
+		{
+			name: "Should not fire, instance tag is not set",
+			process: internal.Process{
+				Status:   "complete",
+				Instance: &internal.Instance{Tags: map[string]string{"team": "ops"}},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"instanceTags.env": "prod",
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should not fire, process was not enriched",
+			process: internal.Process{
+				Status: "complete",
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"instanceTags.env": map[interface{}]interface{}{"glob": "prod*"},
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should fire, when expression uses instance tags and labels",
+			process: internal.Process{
+				Status: "failed",
+				Instance: &internal.Instance{
+					Tags:   map[string]string{"env": "prod"},
+					Labels: []string{"web", "critical"},
+				},
+				Server: &internal.Server{InternalIp: "10.0.0.5"},
+			},
+			hook: Hook{
+				When: `instance.tags.env == "prod" && "critical" in instance.labels && server.internalIp.startsWith("10.")`,
+			},
+			wantFire: true,
+		},
+
+		// enrichment from accounts and users
+		{
+			name: "Should fire, account name matches",
+			process: internal.Process{
+				Status:    "failed",
+				AccountId: sql.NullInt64{Int64: 2},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"accountName": "Acme",
 				},
 			},
-			// NB. only one event will fire in the code as we use 'continue' to move to the next hook
-			// but in our test loop multiple fire events will occur. Expected.
-			wantFireStatus:      true,
-			wantFireProcessType: true,
-			wantFireTaskName:    true,
-			wantFireCreatedBy:   true,
-			wantFireAccountId:   true,
+			wantFire: true,
+		},
+		{
+			name: "Should not fire, account is not cached",
+			process: internal.Process{
+				Status:    "failed",
+				AccountId: sql.NullInt64{Int64: 3},
+			},
+			hook: Hook{
+				Triggers: Trigger{
+					"accountName": "Acme",
+				},
+			},
+			wantFire: false,
+		},
+		{
+			name: "Should fire, created by email matches in when expression",
+			process: internal.Process{
+				Status:      "failed",
+				CreatedById: sql.NullInt64{Int64: 7},
+			},
+			hook: Hook{
+				When: `createdByEmail.endsWith("@acme.com")`,
+			},
+			wantFire: true,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			// status
-			gotFireStatus := checkStatus(&tc.process, &tc.hook)
-			if gotFireStatus != tc.wantFireStatus {
-				t.Errorf("checkStatus wanted %v got %v", tc.wantFireStatus, gotFireStatus)
+			matcher, err := compileTriggers(tc.hook.Triggers)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
 			}
+			tc.hook.matcher = matcher
+			tc.hook.groups = map[string]int{}
+			matcher.captureGroups(tc.hook.groups)
 
-			// processType
-			gotFireProcessType := checkProcessType(&tc.process, &tc.hook)
-			if gotFireProcessType != tc.wantFireProcessType {
-				t.Errorf("checkProcessType wanted %v got %v", tc.wantFireProcessType, gotFireProcessType)
+			if tc.hook.When != "" {
+				program, err := compileWhen(tc.hook.When)
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				tc.hook.program = program
 			}
 
-			// taskName
-			gotFireTaskName := checkTaskName(&tc.process, &tc.hook)
-			if gotFireTaskName != tc.wantFireTaskName {
-				t.Errorf("checkTaskName wanted %v got %v", tc.wantFireTaskName, gotFireTaskName)
+			data := &templateData{safeProcess: newSafeProcess(&tc.process), Matches: map[string][]string{}}
+			gotFire, err := shouldFire(data, &tc.hook)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
 			}
-
-			// AccountId
-			gotFireAccountId := checkAccountId(&tc.process, &tc.hook)
-			if gotFireAccountId != tc.wantFireAccountId {
-				t.Errorf("checkAccountId wanted %v got %v", tc.wantFireAccountId, gotFireAccountId)
+			if gotFire != tc.wantFire {
+				t.Errorf("shouldFire wanted %v got %v", tc.wantFire, gotFire)
 			}
-
-			// CreatedBy
-			gotFireCreatedBy := checkCreatedBy(&tc.process, &tc.hook)
-			if gotFireCreatedBy != tc.wantFireCreatedBy {
-				t.Errorf("checkCreatedBy wanted %v got %v", tc.wantFireCreatedBy, gotFireCreatedBy)
+			if tc.wantMatches != nil && !reflect.DeepEqual(data.Matches, tc.wantMatches) {
+				t.Errorf("matches wanted %v got %v", tc.wantMatches, data.Matches)
 			}
+		})
+	}
+}
+
+// TestCheckEvents checks hooks fire only for the lifecycle events they subscribe to
+func TestCheckEvents(t *testing.T) {
+	ctx := context.Background()
+	logger := &koan.Logger{}
+	st := &state.State{}
+
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received = append(received, string(body))
+		rw.Write([]byte(`ok`))
+	}))
+	defer server.Close()
+
+	newHook := func(description string, events []string, progress []float64) Hook {
+		return Hook{
+			Description: description,
+			URL:         server.URL,
+			Method:      "POST",
+			RequestBody: description + " {{.Event}} {{.Id}}",
+			Events:      events,
+			Progress:    progress,
+			Triggers: Trigger{
+				"taskName": "Backup",
+			},
+		}
+	}
+
+	config = Hooks{
+		{newHook("default", nil, nil)},
+		{newHook("lifecycle", []string{"started", "progress", "failed"}, []float64{50, 90})},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
+
+	process := &internal.Process{
+		Id:       7,
+		Status:   "running",
+		TaskName: sql.NullString{String: "Backup"},
+	}
+
+	testCases := []struct {
+		name    string
+		check   func()
+		wantReq []string
+	}{
+		{
+			"started",
+			func() { CheckStarted(ctx, process, st, logger) },
+			[]string{"lifecycle started 7"},
+		},
+		{
+			"progress not crossing a threshold",
+			func() {
+				process.Percent = sql.NullFloat64{Float64: 40}
+				CheckProgress(ctx, process, 10, st, logger)
+			},
+			nil,
+		},
+		{
+			"progress crossing two thresholds fires once",
+			func() {
+				process.Percent = sql.NullFloat64{Float64: 95}
+				CheckProgress(ctx, process, 40, st, logger)
+			},
+			[]string{"lifecycle progress 7"},
+		},
+		{
+			"completed",
+			func() {
+				process.Status = "complete"
+				CheckProcess(ctx, process, st, logger)
+			},
+			[]string{"default completed 7"},
+		},
+		{
+			"failed",
+			func() {
+				process.Status = "failed"
+				CheckProcess(ctx, process, st, logger)
+			},
+			[]string{"default failed 7", "lifecycle failed 7"},
+		},
+	}
 
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			received = nil
+			tc.check()
+			if !reflect.DeepEqual(received, tc.wantReq) {
+				t.Errorf("wanted %v got %v", tc.wantReq, received)
+			}
 		})
 	}
+}
+
+// TestRecovered checks the recovered event fires when a process completes after the
+// last process with the same correlation failed
+func TestRecovered(t *testing.T) {
+	ctx := context.Background()
+	logger := &koan.Logger{}
+	st := &state.State{}
+
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received = append(received, string(body))
+		rw.Write([]byte(`ok`))
+	}))
+	defer server.Close()
+
+	config = Hooks{
+		{
+			Hook{
+				Description: "open incident",
+				URL:         server.URL,
+				Method:      "POST",
+				RequestBody: "open {{.Id}}",
+				Events:      []string{"failed"},
+				Triggers: Trigger{
+					"taskName": "Backup",
+				},
+			},
+		},
+		{
+			Hook{
+				Description: "close incident",
+				URL:         server.URL,
+				Method:      "POST",
+				RequestBody: "close {{.Id}} {{.Event}}",
+				Events:      []string{"recovered"},
+				CorrelateBy: []string{"taskId", "instanceId"},
+				Triggers: Trigger{
+					"taskName": "Backup",
+				},
+			},
+		},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
 
+	newProcess := func(id int, instanceId int64, status string) *internal.Process {
+		return &internal.Process{
+			Id:         id,
+			Status:     status,
+			TaskName:   sql.NullString{String: "Backup"},
+			TaskId:     sql.NullInt64{Int64: 4},
+			InstanceId: sql.NullInt64{Int64: instanceId},
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		process *internal.Process
+		wantReq []string
+	}{
+		{"first completed", newProcess(1, 10, "complete"), nil},
+		{"failed", newProcess(2, 10, "failed"), []string{"open 2"}},
+		{"failed on other instance", newProcess(3, 11, "failed"), []string{"open 3"}},
+		{"recovered", newProcess(4, 10, "complete"), []string{"close 4 recovered"}},
+		{"completed again", newProcess(5, 10, "complete"), nil},
+		{"recovered on other instance", newProcess(6, 11, "complete"), []string{"close 6 recovered"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			received = nil
+			CheckProcess(ctx, tc.process, st, logger)
+			if !reflect.DeepEqual(received, tc.wantReq) {
+				t.Errorf("wanted %v got %v", tc.wantReq, received)
+			}
+		})
+	}
 }
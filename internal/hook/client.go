@@ -0,0 +1,154 @@
+package hook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// defaultTimeout is the request timeout of hooks which don't set one
+const defaultTimeout = 30 * time.Second
+
+// PROXY_NONE disables the proxy for a hook, otherwise the environment proxy settings are used
+const PROXY_NONE = "none"
+
+// tlsVersions are the minimum TLS versions which can be set
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// transports are shared by hooks with the same proxy and TLS settings so connections are pooled
+var (
+	transports   = map[string]*http.Transport{}
+	transportsMu sync.Mutex
+)
+
+// defaultClient is used by hooks without a client, such as those not validated
+var defaultClient = &http.Client{Timeout: defaultTimeout}
+
+// TLS configures the TLS client of a hook. CAFile is a PEM bundle of certificate authorities trusted
+// in addition to the system pool, CertFile and KeyFile a client certificate for mutual TLS, MinVersion
+// the minimum version, e.g. "1.2", and InsecureSkipVerify disables verification of the server certificate
+type TLS struct {
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	MinVersion         string `yaml:"minVersion"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// compileClient sets the http client of the hook from its timeout, proxy and TLS settings
+func (h *Hook) compileClient() error {
+	timeout := defaultTimeout
+	if h.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(h.Timeout); err != nil || timeout <= 0 {
+			return ERR_BAD_TIMEOUT
+		}
+	}
+
+	transport, err := sharedTransport(h.Proxy, h.TLS)
+	if err != nil {
+		return err
+	}
+
+	h.client = &http.Client{Timeout: timeout, Transport: transport}
+	return nil
+}
+
+// httpClient returns the client the hook makes requests with
+func (h *Hook) httpClient() *http.Client {
+	if h.client == nil {
+		return defaultClient
+	}
+	return h.client
+}
+
+// sharedTransport returns the transport for the proxy and TLS settings, creating it if it's not been used
+func sharedTransport(proxy string, t *TLS) (*http.Transport, error) {
+	key := proxy
+	if t != nil {
+		key = fmt.Sprintf("%s|%+v", proxy, *t)
+	}
+
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if transport, ok := transports[key]; ok {
+		return transport, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	switch proxy {
+	case "":
+		// environment settings, as the default transport
+	case PROXY_NONE:
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("%w: '%s'", ERR_BAD_PROXY, proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if t != nil {
+		config, err := t.config()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+
+	transports[key] = transport
+	return transport, nil
+}
+
+// config builds the tls config, reading the CA bundle and client certificate
+func (t *TLS) config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+
+	if t.MinVersion != "" {
+		version, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("%w: minVersion '%s' should be 1.0, 1.1, 1.2 or 1.3", ERR_BAD_TLS, t.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ERR_BAD_TLS, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates found in caFile '%s'", ERR_BAD_TLS, t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, fmt.Errorf("%w: certFile and keyFile are both required", ERR_BAD_TLS)
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ERR_BAD_TLS, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
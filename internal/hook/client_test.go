@@ -0,0 +1,118 @@
+package hook
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompileClient(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		hook    Hook
+		wantErr error
+	}{
+		{"defaults", Hook{}, nil},
+		{"timeout and proxy", Hook{Timeout: "5s", Proxy: "http://proxy.internal:3128"}, nil},
+		{"no proxy", Hook{Proxy: PROXY_NONE}, nil},
+		{"bad timeout", Hook{Timeout: "soon"}, ERR_BAD_TIMEOUT},
+		{"bad proxy", Hook{Proxy: "proxy.internal"}, ERR_BAD_PROXY},
+		{"tls version", Hook{TLS: &TLS{MinVersion: "1.3"}}, nil},
+		{"bad tls version", Hook{TLS: &TLS{MinVersion: "2"}}, ERR_BAD_TLS},
+		{"ca file missing", Hook{TLS: &TLS{CAFile: filepath.Join(t.TempDir(), "missing.pem")}}, ERR_BAD_TLS},
+		{"ca file without certificates", Hook{TLS: &TLS{CAFile: caFile}}, ERR_BAD_TLS},
+		{"cert without key", Hook{TLS: &TLS{CertFile: caFile}}, ERR_BAD_TLS},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.hook.compileClient(); !errors.Is(err, tc.wantErr) {
+				t.Errorf("wanted %v got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	// hooks with the same settings share a transport
+	a, b := &Hook{Timeout: "1s", Proxy: PROXY_NONE}, &Hook{Timeout: "2s", Proxy: PROXY_NONE}
+	a.compileClient()
+	b.compileClient()
+	if a.client.Transport != b.client.Transport || a.client.Timeout == b.client.Timeout {
+		t.Errorf("wanted shared transport with own timeouts")
+	}
+}
+
+func TestClientRequests(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		rw.Write([]byte(`ok`))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		hook    Hook
+		path    string
+		wantErr bool
+	}{
+		{"self signed not trusted", Hook{}, "/", true},
+		{"insecure skip verify", Hook{TLS: &TLS{InsecureSkipVerify: true}}, "/", false},
+		{"ca file trusted", Hook{TLS: &TLS{CAFile: caFile}}, "/", false},
+		{"timeout", Hook{Timeout: "50ms", TLS: &TLS{CAFile: caFile}}, "/slow", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := tc.hook
+			hook.Description = "test hook"
+			hook.URL = server.URL + tc.path
+			hook.Method = "GET"
+			if err := hook.compileClient(); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			if err := deliver(ctx, nil, nil, &hook); (err != nil) != tc.wantErr {
+				t.Errorf("wanted error %v got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	// requests are sent through the proxy
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		proxied = req.URL.String()
+		rw.Write([]byte(`ok`))
+	}))
+	defer proxy.Close()
+
+	hook := &Hook{Description: "test hook", URL: "http://webhook.internal/hook", Method: "GET", Proxy: proxy.URL}
+	if err := hook.compileClient(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := deliver(ctx, nil, nil, hook); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if proxied != hook.URL {
+		t.Errorf("wanted request for %s through proxy got '%s'", hook.URL, proxied)
+	}
+}
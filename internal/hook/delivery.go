@@ -0,0 +1,338 @@
+package hook
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/queue"
+	"github.com/spoonboy-io/koan"
+)
+
+// Queue holds deliveries until they are made, it is set by the application and, when nil,
+// webhooks are delivered as they fire
+var Queue *queue.Queue
+
+// delivery settings, they can be changed by the application before the queue is processed. Workers
+// is the number of deliveries made at once and QueueLimit the number of deliveries queued before
+// webhooks firing wait for room, which slows the polls
+var (
+	Workers    = internal.DELIVERY_WORKERS
+	QueueLimit = internal.QUEUE_LIMIT
+)
+
+// queuePollInterval is how often the queue is checked for deliveries, in addition to when
+// one is queued, so deliveries recovered on start are made
+const queuePollInterval = 5 * time.Second
+
+// enqueue adds the rendered body and headers to the delivery queue, the caller need not wait for the
+// delivery to be made as the queue is written to disk
+func enqueue(data *templateData, body []byte, headers map[string]string, hook *Hook) error {
+	return Queue.Enqueue(queue.Delivery{
+		Hook:      hook.Description,
+		ProcessId: data.Id,
+		Event:     data.Event,
+		Body:      string(body),
+		Headers:   headers,
+		OrderKey:  hook.orderKey(data),
+		Queued:    time.Now(),
+	})
+}
+
+// ProcessQueue makes the queued deliveries until the context is cancelled, each is removed from the
+// queue once delivered or dead lettered. Deliveries are made by a pool of Workers, with no more in
+// flight for a hook than its concurrency, and those sharing an order key made one at a time in process
+// id order. They are held in the queue while the circuit breaker of their webhook host is open.
+// Deliveries in flight when the context is cancelled remain in the queue and are made again when the
+// application is restarted
+func ProcessQueue(ctx context.Context, logger *koan.Logger) {
+	workers := Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// done is buffered so workers don't wait for the dispatcher to hear they finished
+	jobs := make(chan queue.Delivery)
+	done := make(chan finished, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for d := range jobs {
+				done <- finished{delivery: d, queued: deliverQueued(ctx, d, logger)}
+			}
+		}()
+	}
+	defer close(jobs)
+
+	ds := newDispatcher()
+
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		ds.take(Queue.Since(ds.seen))
+
+		// a worker is idle while fewer deliveries are in flight than there are workers
+		for ds.inFlight < workers {
+			d, ok := ds.next()
+			if !ok {
+				break
+			}
+			jobs <- d
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case f := <-done:
+			ds.done(f.delivery, f.queued)
+		case <-Queue.Notify():
+		case <-ticker.C:
+		}
+	}
+}
+
+// finished is a delivery a worker has made, queued if it remains in the queue to be made again
+type finished struct {
+	delivery queue.Delivery
+	queued   bool
+}
+
+// dispatcher holds the deliveries taken from the queue which are not yet made, by hook, so the next to
+// make is found without going through those held by a circuit breaker or waiting for their order key
+type dispatcher struct {
+	seen     uint64
+	inFlight int
+	lanes    map[string]*lane
+}
+
+// lane holds the deliveries of a hook. Those ready to be made are kept by id, those with an order key
+// are ready only while first for the key, by process id, and no other for the key is in flight
+type lane struct {
+	hook     *Hook
+	breaker  *breaker
+	inFlight int
+	ready    *deliveryHeap
+	ordered  map[string]*ordered
+}
+
+// ordered holds the deliveries of a hook sharing an order key by process id, then id
+type ordered struct {
+	waiting  *deliveryHeap
+	inFlight bool
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{lanes: map[string]*lane{}}
+}
+
+// take adds deliveries newly queued to the lanes of their hooks
+func (ds *dispatcher) take(deliveries []queue.Delivery) {
+	for _, d := range deliveries {
+		ds.add(d)
+		if d.Id > ds.seen {
+			ds.seen = d.Id
+		}
+	}
+}
+
+// add makes the delivery ready, or if it has an order key, waiting for those before it
+func (ds *dispatcher) add(d queue.Delivery) {
+	l := ds.lane(d.Hook)
+	if d.OrderKey == "" {
+		heap.Push(l.ready, d)
+		return
+	}
+
+	o, ok := l.ordered[d.OrderKey]
+	if !ok {
+		o = &ordered{waiting: &deliveryHeap{less: byProcessId}}
+		l.ordered[d.OrderKey] = o
+	}
+	heap.Push(o.waiting, d)
+
+	// a delivery first for its key is ready, one it has taken the place of is discarded by next
+	if o.first(d.Id) {
+		heap.Push(l.ready, d)
+	}
+}
+
+// lane returns the lane of the hook, the hook and the circuit breaker of its host are looked up once
+func (ds *dispatcher) lane(description string) *lane {
+	l, ok := ds.lanes[description]
+	if !ok {
+		l = &lane{
+			hook:    findHook(description),
+			ready:   &deliveryHeap{less: byId},
+			ordered: map[string]*ordered{},
+		}
+		if l.hook != nil {
+			l.breaker = breakerFor(l.hook.URL)
+		}
+		ds.lanes[description] = l
+	}
+	return l
+}
+
+// next returns the ready delivery with the lowest id of the lanes which can make one, and marks it
+// in flight
+func (ds *dispatcher) next() (queue.Delivery, bool) {
+	var first *lane
+	for _, l := range ds.lanes {
+		if !l.available() {
+			continue
+		}
+		if first == nil || l.ready.items[0].Id < first.ready.items[0].Id {
+			first = l
+		}
+	}
+	if first == nil {
+		return queue.Delivery{}, false
+	}
+
+	d := heap.Pop(first.ready).(queue.Delivery)
+	if d.OrderKey != "" {
+		o := first.ordered[d.OrderKey]
+		heap.Pop(o.waiting)
+		o.inFlight = true
+	}
+	first.inFlight++
+	ds.inFlight++
+	return d, true
+}
+
+// done marks the delivery no longer in flight, making ready the next for its order key. A delivery left in
+// the queue, as the circuit breaker of its host opened, is added back to be made again
+func (ds *dispatcher) done(d queue.Delivery, queued bool) {
+	l := ds.lane(d.Hook)
+	l.inFlight--
+	ds.inFlight--
+
+	if d.OrderKey == "" {
+		if queued {
+			heap.Push(l.ready, d)
+		}
+		return
+	}
+
+	o := l.ordered[d.OrderKey]
+	o.inFlight = false
+	if queued {
+		heap.Push(o.waiting, d)
+	}
+	if o.waiting.Len() == 0 {
+		delete(l.ordered, d.OrderKey)
+		return
+	}
+	heap.Push(l.ready, o.waiting.items[0])
+}
+
+// available checks if the lane has a ready delivery which can be made now, ordered deliveries which are
+// no longer first for their key are discarded as they are made ready again when they are
+func (l *lane) available() bool {
+	// held while the hook is at its concurrency
+	if l.hook != nil && l.hook.Concurrency > 0 && l.inFlight >= l.hook.Concurrency {
+		return false
+	}
+
+	for l.ready.Len() > 0 {
+		d := l.ready.items[0]
+		if d.OrderKey == "" {
+			break
+		}
+		if o, ok := l.ordered[d.OrderKey]; ok && o.first(d.Id) {
+			break
+		}
+		heap.Pop(l.ready)
+	}
+	if l.ready.Len() == 0 {
+		return false
+	}
+
+	// held while the circuit breaker of the webhook host is open
+	return l.breaker == nil || !l.breaker.blocked()
+}
+
+// first checks if the delivery is the next to make for the order key
+func (o *ordered) first(id uint64) bool {
+	return !o.inFlight && o.waiting.Len() > 0 && o.waiting.items[0].Id == id
+}
+
+// deliveryHeap is a heap of deliveries, for use with container/heap, which are taken in the order of less
+type deliveryHeap struct {
+	items []queue.Delivery
+	less  func(a, b *queue.Delivery) bool
+}
+
+func (h *deliveryHeap) Len() int           { return len(h.items) }
+func (h *deliveryHeap) Less(i, j int) bool { return h.less(&h.items[i], &h.items[j]) }
+func (h *deliveryHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *deliveryHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(queue.Delivery))
+}
+
+func (h *deliveryHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// byId orders deliveries as they were queued
+func byId(a, b *queue.Delivery) bool {
+	return a.Id < b.Id
+}
+
+// byProcessId orders deliveries by process id, then as they were queued for the same process
+func byProcessId(a, b *queue.Delivery) bool {
+	if a.ProcessId != b.ProcessId {
+		return a.ProcessId < b.ProcessId
+	}
+	return a.Id < b.Id
+}
+
+// orderKey identifies the deliveries of the hook which must be made in order, those for processes with the
+// same values of the orderBy variables, hooks without orderBy are not ordered
+func (h *Hook) orderKey(data *templateData) string {
+	if len(h.orderBy) == 0 {
+		return ""
+	}
+	return variablesKey(h.Description, h.OrderBy, h.orderBy, &data.safeProcess)
+}
+
+// deliverQueued makes the delivery using the current configuration of its hook, deliveries for
+// hooks no longer configured, or which fail after all attempts, are dead lettered. It returns true
+// if the delivery is left in the queue to be made again
+func deliverQueued(ctx context.Context, d queue.Delivery, logger *koan.Logger) bool {
+	var body []byte
+	if d.Body != "" {
+		body = []byte(d.Body)
+	}
+
+	hook := findHook(d.Hook)
+	if hook == nil {
+		err := fmt.Errorf("%w: '%s'", ERR_HOOK_NOT_FOUND, d.Hook)
+		logger.Warn(fmt.Sprintf("Failed to deliver queued webhook (process id: '%d') error: %v", d.ProcessId, err))
+		deadLetter(&Hook{Description: d.Hook}, d.ProcessId, d.Event, body, d.Headers, 0, err, logger)
+	} else {
+		attempts, err := deliverWithRetry(ctx, body, d.Headers, hook, logger)
+		if err != nil {
+			// left in the queue to be made again on restart, or once the circuit breaker closes
+			if errors.Is(err, context.Canceled) || errors.Is(err, ERR_CIRCUIT_OPEN) {
+				return true
+			}
+
+			warnMsg := fmt.Sprintf("Failed to fire webhook (hook: '%s', url: '%s', process id: '%d', attempts: %d) error: %v",
+				hook.Description, hook.URL, d.ProcessId, attempts, err)
+			logger.Warn(warnMsg)
+			deadLetter(hook, d.ProcessId, d.Event, body, d.Headers, attempts, err, logger)
+		}
+	}
+
+	if err := Queue.Done(d.Id); err != nil {
+		logger.Error("Failed to remove delivery from queue", err)
+	}
+	return false
+}
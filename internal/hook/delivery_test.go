@@ -0,0 +1,371 @@
+package hook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal/deadletter"
+	"github.com/spoonboy-io/dozer/internal/queue"
+	"github.com/spoonboy-io/koan"
+)
+
+func TestProcessQueue(t *testing.T) {
+	logger := &koan.Logger{}
+
+	file := filepath.Join(t.TempDir(), queue.FILE_NAME)
+	var err error
+	Queue, err = queue.Open(file)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	DeadLetters = deadletter.New(filepath.Join(t.TempDir(), deadletter.FILE_NAME))
+	defer func() {
+		Queue.Close()
+		Queue = nil
+		DeadLetters = nil
+	}()
+
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received <- req.Header.Get("X-Correlation-Id") + " " + string(body)
+	}))
+	defer server.Close()
+
+	config = Hooks{
+		{
+			Hook{
+				Description: "queued hook",
+				URL:         server.URL,
+				Method:      "POST",
+				RequestBody: "{{.Id}} {{.Event}}",
+				Headers:     map[string]string{"X-Correlation-Id": "dozer-{{.Id}}"},
+				Triggers: Trigger{
+					"taskName": "Backup",
+				},
+			},
+		},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
+
+	// queued but not delivered before the consumer is running
+	sendWebhook(context.Background(), &templateData{safeProcess: safeProcess{Id: 8}, Event: EVENT_COMPLETED}, &config[0].Hook, logger)
+	if err := Queue.Enqueue(queue.Delivery{Hook: "removed hook", ProcessId: 9}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(received) != 0 {
+		t.Fatalf("wanted webhook queued not delivered")
+	}
+
+	// recovered from the log as on restart
+	Queue.Close()
+	if Queue, err = queue.Open(file); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		ProcessQueue(ctx, logger)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	select {
+	case got := <-received:
+		if got != "dozer-8 8 completed" {
+			t.Errorf("wanted 'dozer-8 8 completed' got '%s'", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("wanted queued webhook delivered")
+	}
+
+	// removed once delivered, deliveries for hooks not configured are dead lettered
+	deadline := time.Now().Add(time.Second)
+	for len(Queue.Pending()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending := Queue.Pending(); len(pending) != 0 {
+		t.Errorf("wanted queue empty got %+v", pending)
+	}
+	if letters := DeadLetters.List(); len(letters) != 1 || letters[0].Hook != "removed hook" || letters[0].ProcessId != 9 {
+		t.Errorf("wanted dead letter for removed hook got %+v", letters)
+	}
+}
+
+func TestProcessQueueConcurrency(t *testing.T) {
+	logger := &koan.Logger{}
+
+	var err error
+	Queue, err = queue.Open(filepath.Join(t.TempDir(), queue.FILE_NAME))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	workers := Workers
+	Workers = 3
+	defer func() {
+		Queue.Close()
+		Queue = nil
+		Workers = workers
+	}()
+
+	// track the most requests in flight at once for each hook
+	var mu sync.Mutex
+	inFlight, most := map[string]int{}, map[string]int{}
+	delivered := make(chan string, 20)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		name := req.URL.Path
+		mu.Lock()
+		inFlight[name]++
+		inFlight["total"]++
+		for _, key := range []string{name, "total"} {
+			if inFlight[key] > most[key] {
+				most[key] = inFlight[key]
+			}
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight[name]--
+		inFlight["total"]--
+		mu.Unlock()
+		delivered <- name
+	}))
+	defer server.Close()
+
+	config = Hooks{
+		{Hook{Description: "limited hook", URL: server.URL + "/limited", Method: "GET", Concurrency: 1, Triggers: Trigger{"taskName": "Backup"}}},
+		{Hook{Description: "open hook", URL: server.URL + "/open", Method: "GET", Triggers: Trigger{"taskName": "Backup"}}},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
+
+	for i := 0; i < 4; i++ {
+		for _, hook := range []string{"limited hook", "open hook"} {
+			if err := Queue.Enqueue(queue.Delivery{Hook: hook, ProcessId: i}); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		ProcessQueue(ctx, logger)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	for i := 0; i < 8; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("wanted 8 deliveries got %d", i)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if most["/limited"] != 1 {
+		t.Errorf("wanted 1 limited hook request at once got %d", most["/limited"])
+	}
+	if most["total"] > 3 {
+		t.Errorf("wanted no more than 3 requests at once got %d", most["total"])
+	}
+}
+
+func Test_dispatcher(t *testing.T) {
+	config = Hooks{
+		{Hook{Description: "ordered hook", URL: "http://ordered.dozer.test/hook"}},
+		{Hook{Description: "limited hook", URL: "http://limited.dozer.test/hook", Concurrency: 1}},
+		{Hook{Description: "held hook", URL: "http://held.dozer.test/hook"}},
+	}
+	defer func() { config = nil }()
+
+	// the circuit breaker of the held hook's host is open
+	held := breakerFor("http://held.dozer.test")
+	held.state, held.opened = BREAKER_OPEN, time.Now()
+	defer func() {
+		breakersMu.Lock()
+		delete(breakers, held.host)
+		breakersMu.Unlock()
+	}()
+
+	ds := newDispatcher()
+	ds.take([]queue.Delivery{
+		{Id: 1, Hook: "ordered hook", ProcessId: 103, OrderKey: "a"},
+		{Id: 2, Hook: "ordered hook", ProcessId: 101, OrderKey: "a"},
+		{Id: 3, Hook: "limited hook", ProcessId: 1},
+		{Id: 4, Hook: "limited hook", ProcessId: 2},
+		{Id: 5, Hook: "held hook", ProcessId: 1},
+		{Id: 6, Hook: "removed hook", ProcessId: 1},
+	})
+	if ds.seen != 6 {
+		t.Errorf("wanted deliveries seen to 6 got %d", ds.seen)
+	}
+
+	next := func() []uint64 {
+		var ids []uint64
+		for {
+			d, ok := ds.next()
+			if !ok {
+				return ids
+			}
+			ids = append(ids, d.Id)
+		}
+	}
+
+	// the lowest process id for the order key, one for the limited hook, none for the held host
+	if got, want := next(), []uint64{2, 3, 6}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("wanted %v got %v", want, got)
+	}
+	if ds.inFlight != 3 {
+		t.Errorf("wanted 3 in flight got %d", ds.inFlight)
+	}
+
+	// the next for the order key and limited hook once those in flight are done
+	ds.done(queue.Delivery{Id: 2, Hook: "ordered hook", ProcessId: 101, OrderKey: "a"}, false)
+	ds.done(queue.Delivery{Id: 3, Hook: "limited hook", ProcessId: 1}, false)
+	if got, want := next(), []uint64{1, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("wanted %v got %v", want, got)
+	}
+
+	// left in the queue, it is made again
+	ds.done(queue.Delivery{Id: 1, Hook: "ordered hook", ProcessId: 103, OrderKey: "a"}, true)
+	if got, want := next(), []uint64{1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("wanted %v got %v", want, got)
+	}
+
+	// made once the circuit breaker closes
+	held.mu.Lock()
+	held.state = BREAKER_CLOSED
+	held.mu.Unlock()
+	if got, want := next(), []uint64{5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("wanted %v got %v", want, got)
+	}
+}
+
+func TestProcessQueueOrder(t *testing.T) {
+	logger := &koan.Logger{}
+
+	var err error
+	Queue, err = queue.Open(filepath.Join(t.TempDir(), queue.FILE_NAME))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	workers := Workers
+	Workers = 4
+	defer func() {
+		Queue.Close()
+		Queue = nil
+		Workers = workers
+	}()
+
+	// record the order processes are delivered for each instance, and the most in flight at once
+	var mu sync.Mutex
+	order := map[string][]string{}
+	inFlight, most := map[string]int{}, map[string]int{}
+	delivered := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		fields := strings.Fields(string(body))
+		instance, id := fields[0], fields[1]
+
+		mu.Lock()
+		inFlight[instance]++
+		if inFlight[instance] > most[instance] {
+			most[instance] = inFlight[instance]
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight[instance]--
+		order[instance] = append(order[instance], id)
+		mu.Unlock()
+		delivered <- struct{}{}
+	}))
+	defer server.Close()
+
+	config = Hooks{
+		{
+			Hook{
+				Description: "ordered hook",
+				URL:         server.URL,
+				Method:      "POST",
+				RequestBody: "{{.InstanceId}} {{.Id}}",
+				OrderBy:     []string{"instanceId"},
+				Triggers: Trigger{
+					"taskName": "Backup",
+				},
+			},
+		},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
+
+	// queued out of process id order, as when a process finishes after a later one
+	processes := []safeProcess{
+		{Id: 103, InstanceId: 1},
+		{Id: 201, InstanceId: 2},
+		{Id: 101, InstanceId: 1},
+		{Id: 202, InstanceId: 2},
+		{Id: 102, InstanceId: 1},
+	}
+	for _, sp := range processes {
+		sendWebhook(context.Background(), &templateData{safeProcess: sp, Event: EVENT_COMPLETED}, &config[0].Hook, logger)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		ProcessQueue(ctx, logger)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	for i := 0; i < len(processes); i++ {
+		select {
+		case <-delivered:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("wanted %d deliveries got %d", len(processes), i)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string][]string{"1": {"101", "102", "103"}, "2": {"201", "202"}}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("wanted %v got %v", want, order)
+	}
+	if most["1"] != 1 || most["2"] != 1 {
+		t.Errorf("wanted one delivery at once for each instance got %v", most)
+	}
+}
@@ -0,0 +1,221 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// responseLimit is the number of bytes of the response body read to check expectResponse
+const responseLimit = 1024 * 1024
+
+// statusRange is an inclusive range of response statuses, a single status has min and max equal
+type statusRange struct {
+	min int
+	max int
+}
+
+// ExpectResponse configures a check of the response body for the delivery to succeed. If JSONPath is set
+// the value it selects, e.g. `$.result.status` or `$.items[0].id`, is checked, otherwise the whole body.
+// The value must equal Equals and match the Regex, if they are set
+type ExpectResponse struct {
+	JSONPath string `yaml:"jsonPath"`
+	Equals   string `yaml:"equals"`
+	Regex    string `yaml:"regex"`
+
+	// regex is the compiled Regex
+	regex *regexp.Regexp
+}
+
+// assertionError is returned when the response status is expected but the body is not, the webhook
+// responded so it is not retried and does not count against the circuit breaker of the host
+type assertionError struct {
+	description string
+	reason      string
+}
+
+func (e *assertionError) Error() string {
+	return fmt.Sprintf("Unexpected response: Hook: %s, %s", e.description, e.reason)
+}
+
+// compileExpectStatus parses ExpectStatus which is a status, a range such as "200-299", or a list of them
+func (h *Hook) compileExpectStatus() error {
+	h.expectStatus = nil
+	if h.ExpectStatus == nil {
+		return nil
+	}
+
+	values, ok := h.ExpectStatus.([]interface{})
+	if !ok {
+		values = []interface{}{h.ExpectStatus}
+	}
+
+	for _, value := range values {
+		var r statusRange
+		switch v := value.(type) {
+		case int:
+			r = statusRange{v, v}
+		case string:
+			bounds := strings.SplitN(v, "-", 2)
+			min, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+			if err != nil {
+				return fmt.Errorf("%w: '%s'", ERR_BAD_EXPECT_STATUS, v)
+			}
+			r = statusRange{min, min}
+			if len(bounds) == 2 {
+				if r.max, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+					return fmt.Errorf("%w: '%s'", ERR_BAD_EXPECT_STATUS, v)
+				}
+			}
+		default:
+			return fmt.Errorf("%w: '%v'", ERR_BAD_EXPECT_STATUS, v)
+		}
+
+		if r.min < 100 || r.max > 599 || r.min > r.max {
+			return fmt.Errorf("%w: '%v'", ERR_BAD_EXPECT_STATUS, value)
+		}
+		h.expectStatus = append(h.expectStatus, r)
+	}
+	return nil
+}
+
+// expects checks if the response status is one the hook expects, hooks without expectStatus expect 200
+func (h *Hook) expects(status int) bool {
+	if len(h.expectStatus) == 0 {
+		return status == 200
+	}
+	for _, r := range h.expectStatus {
+		if status >= r.min && status <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// compile checks the response expectation and compiles the regex
+func (e *ExpectResponse) compile() error {
+	if e.Equals == "" && e.Regex == "" {
+		return fmt.Errorf("%w: equals or regex is required", ERR_BAD_EXPECT_RESPONSE)
+	}
+
+	if e.JSONPath != "" {
+		if _, err := parseJSONPath(e.JSONPath); err != nil {
+			return fmt.Errorf("%w: %v", ERR_BAD_EXPECT_RESPONSE, err)
+		}
+	}
+
+	if e.Regex != "" {
+		regex, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ERR_BAD_EXPECT_RESPONSE, err)
+		}
+		e.regex = regex
+	}
+	return nil
+}
+
+// check returns the reason the response body is not as expected, or an empty string
+func (e *ExpectResponse) check(body []byte) string {
+	value := string(body)
+	if e.JSONPath != "" {
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "response is not JSON"
+		}
+
+		selected, ok := selectJSONPath(doc, e.JSONPath)
+		if !ok {
+			return fmt.Sprintf("%s not found", e.JSONPath)
+		}
+		if s, isString := selected.(string); isString {
+			value = s
+		} else {
+			encoded, _ := json.Marshal(selected)
+			value = string(encoded)
+		}
+	}
+
+	if e.Equals != "" && value != e.Equals {
+		return fmt.Sprintf("'%s' does not equal '%s'", value, e.Equals)
+	}
+	if e.regex != nil && !e.regex.MatchString(value) {
+		return fmt.Sprintf("'%s' does not match '%s'", value, e.Regex)
+	}
+	return ""
+}
+
+// pathStep is a step of a JSON path, an object key or, when key is empty, an array index
+type pathStep struct {
+	key   string
+	index int
+}
+
+// parseJSONPath parses the dot notation subset of JSONPath, object keys and array indexes, e.g. `$.items[0].id`
+func parseJSONPath(path string) ([]pathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("jsonPath '%s' should start with $", path)
+	}
+
+	var steps []pathStep
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("jsonPath '%s' has an empty key", path)
+			}
+			steps = append(steps, pathStep{key: key})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("jsonPath '%s' has an unclosed index", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("jsonPath '%s' has an index which is not valid", path)
+			}
+			steps = append(steps, pathStep{index: index})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("jsonPath '%s' is not valid", path)
+		}
+	}
+	return steps, nil
+}
+
+// selectJSONPath returns the value of the decoded JSON document at the path
+func selectJSONPath(doc interface{}, path string) (interface{}, bool) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false
+	}
+
+	value := doc
+	for _, step := range steps {
+		if step.key != "" {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[step.key]; !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		array, ok := value.([]interface{})
+		if !ok || step.index >= len(array) {
+			return nil, false
+		}
+		value = array[step.index]
+	}
+	return value, true
+}
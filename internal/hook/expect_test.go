@@ -0,0 +1,110 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExpects(t *testing.T) {
+	testCases := []struct {
+		name         string
+		expectStatus interface{}
+		status       int
+		want         bool
+	}{
+		{"default is 200", nil, 200, true},
+		{"default is only 200", nil, 201, false},
+		{"single status", 204, 204, true},
+		{"range", "200-299", 202, true},
+		{"outside range", "200-299", 302, false},
+		{"list", []interface{}{"200-201", 204}, 204, true},
+		{"not in list", []interface{}{"200-201", 204}, 202, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := &Hook{ExpectStatus: tc.expectStatus}
+			if err := hook.compileExpectStatus(); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if got := hook.expects(tc.status); got != tc.want {
+				t.Errorf("wanted %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExpectResponseCheck(t *testing.T) {
+	body := []byte(`{"result": {"status": "accepted", "count": 2, "ok": true}, "items": [{"id": "a1"}, {"id": "b2"}]}`)
+
+	testCases := []struct {
+		name   string
+		expect ExpectResponse
+		body   []byte
+		wantOK bool
+	}{
+		{"string equals", ExpectResponse{JSONPath: "$.result.status", Equals: "accepted"}, body, true},
+		{"string not equal", ExpectResponse{JSONPath: "$.result.status", Equals: "rejected"}, body, false},
+		{"number equals", ExpectResponse{JSONPath: "$.result.count", Equals: "2"}, body, true},
+		{"bool equals", ExpectResponse{JSONPath: "$.result.ok", Equals: "true"}, body, true},
+		{"array index", ExpectResponse{JSONPath: "$.items[1].id", Equals: "b2"}, body, true},
+		{"index out of range", ExpectResponse{JSONPath: "$.items[2].id", Equals: "b2"}, body, false},
+		{"path not found", ExpectResponse{JSONPath: "$.result.state", Equals: "accepted"}, body, false},
+		{"path regex", ExpectResponse{JSONPath: "$.result.status", Regex: "^accept"}, body, true},
+		{"body regex", ExpectResponse{Regex: `"ok":\s*true`}, body, true},
+		{"body regex not matched", ExpectResponse{Regex: `error`}, body, false},
+		{"not json", ExpectResponse{JSONPath: "$.result", Equals: "ok"}, []byte(`ok`), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.expect.compile(); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if reason := tc.expect.check(tc.body); (reason == "") != tc.wantOK {
+				t.Errorf("wanted ok %v got '%s'", tc.wantOK, reason)
+			}
+		})
+	}
+}
+
+func Test_deliverExpected(t *testing.T) {
+	ctx := context.Background()
+
+	var gotMethod string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		gotMethod = req.Method
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte(`{"result": {"status": "queued"}}`))
+	}))
+	defer server.Close()
+
+	hook := &Hook{Description: "test hook", URL: server.URL, Method: "PUT", ExpectStatus: "200-299"}
+	if err := hook.compileExpectStatus(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if err := deliver(ctx, []byte(`{}`), nil, hook); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if gotMethod != "PUT" {
+		t.Errorf("wanted PUT got %s", gotMethod)
+	}
+
+	// the response is not as expected, it is not retried
+	hook.ExpectResponse = &ExpectResponse{JSONPath: "$.result.status", Equals: "accepted"}
+	if err := hook.ExpectResponse.compile(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	err := deliver(ctx, []byte(`{}`), nil, hook)
+	var ae *assertionError
+	if !errors.As(err, &ae) {
+		t.Fatalf("wanted assertion error got %v", err)
+	}
+	if (&Retry{StatusCodes: defaultRetryStatus}).retryable(err) || hostDown(err) {
+		t.Errorf("wanted assertion error not retried or counted against the host")
+	}
+}
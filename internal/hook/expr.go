@@ -0,0 +1,122 @@
+package hook
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/spoonboy-io/dozer/internal"
+)
+
+// newWhenEnv creates the CEL environment used to compile `when` expressions. A variable is declared
+// for every safeProcess field, named using the lowerCamel form of the field so that it reads the same
+// as the YAML, e.g. `status == "failed" && duration > 600 && instanceName.startsWith("prod-")`
+func newWhenEnv() (*cel.Env, error) {
+	var opts []cel.EnvOption
+	t := reflect.TypeOf(safeProcess{})
+	for i := 0; i < t.NumField(); i++ {
+		celType, err := celTypeOf(t.Field(i).Type)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, cel.Variable(lowerFirst(t.Field(i).Name), celType))
+	}
+	return cel.NewEnv(opts...)
+}
+
+// compileWhen compiles and type checks a `when` expression, returning a program which can be evaluated
+// against processes. Expressions must evaluate to a bool
+func compileWhen(expression string) (cel.Program, error) {
+	env, err := newWhenEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ast, iss := env.Compile(expression)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("%w: %v", ERR_BAD_WHEN, iss.Err())
+	}
+
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("%w: expression returns %v not bool", ERR_BAD_WHEN, ast.OutputType())
+	}
+
+	return env.Program(ast)
+}
+
+// evalWhen runs a compiled `when` expression against the process
+func evalWhen(program cel.Program, sp *safeProcess) (bool, error) {
+	out, _, err := program.Eval(whenVars(sp))
+	if err != nil {
+		return false, err
+	}
+
+	fire, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("when expression returned %v not bool", out.Type())
+	}
+	return fire, nil
+}
+
+// whenVars maps the safeProcess fields to the variables declared in newWhenEnv, content fields are cut
+// to internal.CONTENT_MATCH_LIMIT bytes as they are for triggers
+func whenVars(sp *safeProcess) map[string]interface{} {
+	vars := structVars(reflect.ValueOf(sp).Elem())
+	for key := range contentFields {
+		if text, ok := vars[key].(string); ok && len(text) > internal.CONTENT_MATCH_LIMIT {
+			vars[key] = text[:internal.CONTENT_MATCH_LIMIT]
+		}
+	}
+	return vars
+}
+
+// structVars maps the fields of a struct to lowerCamel variables, nested structs, such as the
+// enriched instance, are mapped in the same way so they can be used as CEL maps, e.g. `instance.tags.env`
+func structVars(v reflect.Value) map[string]interface{} {
+	vars := map[string]interface{}{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct && field.Type() != reflect.TypeOf(time.Time{}) {
+			vars[lowerFirst(v.Type().Field(i).Name)] = structVars(field)
+			continue
+		}
+		vars[lowerFirst(v.Type().Field(i).Name)] = field.Interface()
+	}
+	return vars
+}
+
+// celTypeOf returns the CEL type for the go types we use in safeProcess
+func celTypeOf(t reflect.Type) (*cel.Type, error) {
+	if t == reflect.TypeOf(time.Time{}) {
+		return cel.TimestampType, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return cel.MapType(cel.StringType, cel.DynType), nil
+	case reflect.Map:
+		return cel.MapType(cel.StringType, cel.StringType), nil
+	case reflect.Slice:
+		return cel.ListType(cel.StringType), nil
+	case reflect.String:
+		return cel.StringType, nil
+	case reflect.Int, reflect.Int64:
+		return cel.IntType, nil
+	case reflect.Float64:
+		return cel.DoubleType, nil
+	case reflect.Bool:
+		return cel.BoolType, nil
+	default:
+		return nil, fmt.Errorf("no expression type for %v", t)
+	}
+}
+
+// lowerFirst converts an exported field name to the lowerCamel form used in the YAML
+func lowerFirst(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
@@ -0,0 +1,134 @@
+package hook
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+)
+
+func Test_compileWhen(t *testing.T) {
+	testCases := []struct {
+		name       string
+		expression string
+		wantErr    error
+	}{
+		{
+			"compiles, string, int and bool variables",
+			`status == "failed" && accountId == 2 && !success`,
+			nil,
+		},
+		{
+			"compiles, string functions",
+			`instanceName.startsWith("prod-") || taskName.matches("^Deploy")`,
+			nil,
+		},
+		{
+			"compiles, timestamp variables",
+			`endDate - startDate > duration("10m")`,
+			nil,
+		},
+		{
+			"fails, unknown variable",
+			`stauts == "failed"`,
+			ERR_BAD_WHEN,
+		},
+		{
+			"fails, type mismatch",
+			`accountId == "2"`,
+			ERR_BAD_WHEN,
+		},
+		{
+			"fails, does not return bool",
+			`accountId + 1`,
+			ERR_BAD_WHEN,
+		},
+		{
+			"fails, syntax error",
+			`status == `,
+			ERR_BAD_WHEN,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, gotErr := compileWhen(tc.expression)
+			if !errors.Is(gotErr, tc.wantErr) {
+				t.Errorf("wanted %v got %v", tc.wantErr, gotErr)
+			}
+		})
+	}
+}
+
+func Test_evalWhen(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		process    internal.Process
+		expression string
+		want       bool
+	}{
+		{
+			"matches on status and id",
+			internal.Process{Id: 10, Status: "failed"},
+			`id == 10 && status == "failed"`,
+			true,
+		},
+		{
+			"no match on status",
+			internal.Process{Id: 10, Status: "complete"},
+			`status == "failed"`,
+			false,
+		},
+		{
+			"matches on timestamps",
+			internal.Process{
+				StartDate: sql.NullTime{Time: start, Valid: true},
+				EndDate:   sql.NullTime{Time: start.Add(time.Hour), Valid: true},
+			},
+			`endDate - startDate > duration("30m")`,
+			true,
+		},
+		{
+			"output beyond the content match limit is not inspected",
+			internal.Process{Output: sql.NullString{String: strings.Repeat("x", internal.CONTENT_MATCH_LIMIT) + "WARNING"}},
+			`output.contains("WARNING")`,
+			false,
+		},
+		{
+			"output within the content match limit is inspected",
+			internal.Process{Output: sql.NullString{String: "WARNING" + strings.Repeat("x", internal.CONTENT_MATCH_LIMIT)}},
+			`output.contains("WARNING")`,
+			true,
+		},
+		{
+			"matches on string function",
+			internal.Process{CreatedBy: sql.NullString{String: "svc-ci"}},
+			`createdBy.startsWith("svc-")`,
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			program, err := compileWhen(tc.expression)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			sp := newSafeProcess(&tc.process)
+			got, err := evalWhen(program, &sp)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("wanted %v got %v", tc.want, got)
+			}
+		})
+	}
+}
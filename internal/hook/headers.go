@@ -0,0 +1,49 @@
+package hook
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// compileHeaders parses the header value templates, executing them against data so any
+// variables included are checked
+func (h *Hook) compileHeaders(data *templateData) error {
+	h.headers = map[string]*template.Template{}
+	for name, value := range h.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("%w: '%s' is not a valid header name", ERR_BAD_HEADER, name)
+		}
+
+		t, err := template.New(name).Parse(value)
+		if err != nil {
+			return fmt.Errorf("%w: %s %v", ERR_BAD_HEADER, name, err)
+		}
+		if err := t.Execute(&bytes.Buffer{}, data); err != nil {
+			return fmt.Errorf("%w: %s %v", ERR_BAD_HEADER, name, err)
+		}
+		h.headers[name] = t
+	}
+	return nil
+}
+
+// renderHeaders renders the header values of the hook with the template data
+func renderHeaders(data *templateData, hook *Hook) (map[string]string, error) {
+	if len(hook.headers) == 0 {
+		return nil, nil
+	}
+
+	headers := map[string]string{}
+	for name, t := range hook.headers {
+		var value bytes.Buffer
+		if err := t.Execute(&value, data); err != nil {
+			return nil, err
+		}
+		if strings.ContainsAny(value.String(), "\r\n") {
+			return nil, fmt.Errorf("%w: %s value contains a line break", ERR_BAD_HEADER, name)
+		}
+		headers[name] = value.String()
+	}
+	return headers, nil
+}
@@ -4,9 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
+	"text/template"
+	"time"
 
+	"github.com/google/cel-go/cel"
 	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
 
 	"gopkg.in/yaml.v2"
 )
@@ -20,24 +25,93 @@ type Hooks []struct {
 
 // Hook represents the configuration of a single webhook
 type Hook struct {
-	Description string  `yaml:"description"`
-	URL         string  `yaml:"url"`
-	Method      string  `yaml:"method"`
-	Token       string  `yaml:"token"`
-	RequestBody string  `yaml:"requestBody"`
-	Triggers    Trigger `yaml:"triggers"`
+	Description string            `yaml:"description"`
+	URL         string            `yaml:"url"`
+	Method      string            `yaml:"method"`
+	Token       string            `yaml:"token"`
+	Auth        *Auth             `yaml:"auth"`
+	RequestBody string            `yaml:"requestBody"`
+	Triggers    Trigger           `yaml:"triggers"`
+	When        string            `yaml:"when"`
+	Stuck       string            `yaml:"stuck"`
+	Events      []string          `yaml:"events"`
+	Progress    []float64         `yaml:"progress"`
+	Aggregate   string            `yaml:"aggregate"`
+	Threshold   *Threshold        `yaml:"threshold"`
+	Suppress    *Suppress         `yaml:"suppress"`
+	Absence     string            `yaml:"absence"`
+	CorrelateBy []string          `yaml:"correlateBy"`
+	Retry       *Retry            `yaml:"retry"`
+	Concurrency int               `yaml:"concurrency"`
+	OrderBy     []string          `yaml:"orderBy"`
+	Signing     *Signing          `yaml:"signing"`
+	Headers     map[string]string `yaml:"headers"`
+
+	// ExpectStatus is a status, a range such as "200-299", or a list of them
+	ExpectStatus   interface{}     `yaml:"expectStatus"`
+	ExpectResponse *ExpectResponse `yaml:"expectResponse"`
+
+	// Timeout, Proxy and TLS configure the http client of the hook
+	Timeout string `yaml:"timeout"`
+	Proxy   string `yaml:"proxy"`
+	TLS     *TLS   `yaml:"tls"`
+
+	// matcher and program are the compiled Triggers and When expression, stuckAfter and absentAfter
+	// are the parsed Stuck and Absence durations, all are set by ValidateConfig
+	matcher     triggerSet
+	program     cel.Program
+	stuckAfter  time.Duration
+	absentAfter time.Duration
+
+	// groups holds the number of values each regex trigger captures, see fillMatches
+	groups map[string]int
+
+	// correlateBy and orderBy hold the field indexes of the CorrelateBy and OrderBy variables
+	correlateBy []int
+	orderBy     []int
+
+	// headers holds the parsed Headers value templates
+	headers map[string]*template.Template
+
+	// expectStatus holds the parsed ExpectStatus ranges
+	expectStatus []statusRange
+
+	// client makes the requests of the hook, it shares a transport with hooks with the same settings
+	client *http.Client
 }
 
-// Trigger represents the trigger configuration options which can be set in the YAML.
-// They are additive, in that all set, must be satisfied for the hook event to be fired
-type Trigger struct {
-	Status      string `yaml:"status"`
-	ProcessType string `yaml:"processType"`
-	TaskName    string `yaml:"taskName"`
-	AccountId   int    `yaml:"accountId"`
-	CreatedBy   string `yaml:"createdBy"`
+// Suppress configures a hook to drop events which repeat within the Window, repeats are identified by
+// the Key template, e.g. `{{.InstanceId}}-{{.TaskName}}`. When the window closes the Summary request body,
+// if set, is sent with the count of dropped events
+type Suppress struct {
+	Key     string `yaml:"key"`
+	Window  string `yaml:"window"`
+	Summary string `yaml:"summary"`
+
+	// key and window are the parsed Key template and Window duration
+	key    *template.Template
+	window time.Duration
+}
+
+// Threshold configures a hook to fire only when Count matching processes are seen Within the duration,
+// counted separately for each combination of the By process variables, e.g. `by: [accountId]`
+type Threshold struct {
+	Count  int      `yaml:"count"`
+	Within string   `yaml:"within"`
+	By     []string `yaml:"by"`
+
+	// within and by are the parsed duration and the field indexes of the By variables
+	within time.Duration
+	by     []int
 }
 
+// Trigger represents the trigger configuration options which can be set in the YAML. Keys are the lowerCamel
+// names of the process variables available to the requestBody, e.g. `zoneId` or `instanceName`, and
+// `processType` which takes a process type code. Values may be a list, any one of which must match.
+// They are additive, in that all set, must be satisfied for the hook event to be fired. A `not` block
+// holds triggers which must not match and `anyOf` a list of triggers, one of which must match
+type Trigger map[string]interface{}
+
 // adding some standard errors we can check in the tests
 // specifically for validating the config
 var (
@@ -45,11 +119,39 @@ var (
 	ERR_BAD_METHOD                  = errors.New("method is not acceptable")
 	ERR_BAD_URL                     = errors.New("url is appears to be invalid")
 	ERR_NO_BODY                     = errors.New("method requires requestBody")
-	ERR_NO_TRIGGER                  = errors.New("No triggers defined in the hook")
+	ERR_NO_TRIGGER                  = errors.New("No triggers or when expression defined in the hook")
 	ERR_BAD_STATUS_TRIGGER          = errors.New("Trigger set on status is not recognised")
 	ERR_NO_EXECUTING_STATUS_TRIGGER = errors.New("Can not trigger on status 'executing'")
 	ERR_NOT_HTTPS                   = errors.New("url is not secure (no HTTPS)")
 	ERR_COULD_NOT_PARSE_BODY        = errors.New("Problem parsing request body, check included variables")
+	ERR_BAD_WHEN                    = errors.New("when expression is not valid")
+	ERR_UNKNOWN_TRIGGER             = errors.New("Trigger is not a recognised process variable")
+	ERR_BAD_TRIGGER_VALUE           = errors.New("Trigger value can not be matched against the process variable")
+	ERR_DUPLICATE_DESCRIPTION       = errors.New("description is used by another hook")
+	ERR_BAD_STUCK                   = errors.New("stuck should be a duration such as 90m or 2h")
+	ERR_BAD_EVENT                   = errors.New("Event is not recognised")
+	ERR_STUCK_WITH_EVENTS           = errors.New("stuck hooks fire their own events, remove events")
+	ERR_NO_PROGRESS                 = errors.New("progress event requires progress percentages")
+	ERR_BAD_PROGRESS                = errors.New("progress percentages should be more than 0 and no more than 100")
+	ERR_BAD_AGGREGATE               = errors.New("aggregate should be 'workflow' and only fire on completed or failed events")
+	ERR_BAD_THRESHOLD               = errors.New("threshold requires a count of 1 or more, a within duration and known by variables")
+	ERR_BAD_SUPPRESS                = errors.New("suppress requires a key template and a window duration")
+	ERR_BAD_CORRELATE               = errors.New("recovered event requires correlateBy process variables and can not be used with stuck, aggregate or absence")
+	ERR_BAD_RETRY                   = errors.New("retry policy is not valid")
+	ERR_HOOK_NOT_FOUND              = errors.New("No hook with the description is configured")
+	ERR_CIRCUIT_OPEN                = errors.New("circuit breaker is open for the webhook host")
+	ERR_BAD_HEADER                  = errors.New("header should have a name and a value template")
+	ERR_BAD_EXPECT_STATUS           = errors.New("expectStatus should be a status, a range such as 200-299, or a list of them")
+	ERR_BAD_EXPECT_RESPONSE         = errors.New("expectResponse requires equals or regex and a valid jsonPath")
+	ERR_BAD_TIMEOUT                 = errors.New("timeout should be a duration such as 10s")
+	ERR_BAD_PROXY                   = errors.New("proxy should be a url or 'none'")
+	ERR_BAD_TLS                     = errors.New("tls settings are not valid")
+	ERR_BAD_AUTH                    = errors.New("auth is not valid")
+	ERR_AUTH_TOKEN                  = errors.New("Failed to get oauth2 access token")
+	ERR_BAD_CONCURRENCY             = errors.New("concurrency should be 0, for no limit, or more")
+	ERR_BAD_ORDER_BY                = errors.New("orderBy should be a list of process variables")
+	ERR_BAD_SIGNING                 = errors.New("signing requires a secret and an algorithm of sha256 or sha512")
+	ERR_BAD_ABSENCE                 = errors.New("absence should be a duration such as 26h and can not be used with stuck, aggregate, threshold or suppress")
 )
 
 // ReadAndParseConfig reads the contents of the YAML hook config filer
@@ -79,11 +181,16 @@ func getProcessTypeName(code string) (string, error) {
 }
 
 func ValidateConfig() error {
+	descriptions := map[string]bool{}
 	for i := range config {
-		// check description
+		// check description, it identifies the hook in saved state so must be unique
 		if config[i].Description == "" {
 			return ERR_NO_DESCRIPTION
 		}
+		if descriptions[config[i].Description] {
+			return ERR_DUPLICATE_DESCRIPTION
+		}
+		descriptions[config[i].Description] = true
 
 		// check method
 		if err := isGoodMethod(config[i].Method); err != nil {
@@ -103,15 +210,135 @@ func ValidateConfig() error {
 			}
 		*/
 
+		// check at least one trigger
+		if err := checkTriggers(config[i].Triggers, config[i].When); err != nil {
+			return err
+		}
+
+		matcher, err := compileTriggers(config[i].Triggers)
+		if err != nil {
+			return err
+		}
+		config[i].matcher = matcher
+		config[i].groups = map[string]int{}
+		matcher.captureGroups(config[i].groups)
+
 		// if method POST/PUT check request body is present
-		if err := shouldHaveRequestBody(config[i].Method, config[i].RequestBody); err != nil {
+		if err := shouldHaveRequestBody(config[i].Method, config[i].RequestBody, matcher); err != nil {
 			return err
 		}
 
-		// check at least one trigger
-		if err := checkTriggers(config[i].Triggers); err != nil {
+		// compile the when expression so errors are found now and not when the hook fires
+		if config[i].When != "" {
+			program, err := compileWhen(config[i].When)
+			if err != nil {
+				return err
+			}
+			config[i].program = program
+		}
+
+		if config[i].Stuck != "" {
+			stuckAfter, err := time.ParseDuration(config[i].Stuck)
+			if err != nil || stuckAfter <= 0 {
+				return ERR_BAD_STUCK
+			}
+			config[i].stuckAfter = stuckAfter
+
+			if len(config[i].Events) > 0 {
+				return ERR_STUCK_WITH_EVENTS
+			}
+		}
+
+		if err := checkEvents(config[i].Events, config[i].Progress); err != nil {
+			return err
+		}
+
+		if err := checkAggregate(config[i].Aggregate, config[i].Stuck, config[i].Events); err != nil {
+			return err
+		}
+
+		if config[i].Threshold != nil {
+			// stuck and aggregating hooks fire once for a process or workflow so can't count
+			if config[i].Stuck != "" || config[i].Aggregate != "" {
+				return ERR_BAD_THRESHOLD
+			}
+			if err := config[i].Threshold.compile(); err != nil {
+				return err
+			}
+		}
+
+		if config[i].Suppress != nil {
+			if config[i].Stuck != "" || config[i].Aggregate != "" {
+				return fmt.Errorf("%w: can not be used with stuck or aggregate", ERR_BAD_SUPPRESS)
+			}
+			if err := config[i].Suppress.compile(dummyTemplateData(matcher)); err != nil {
+				return err
+			}
+		}
+
+		if config[i].Absence != "" {
+			absentAfter, err := time.ParseDuration(config[i].Absence)
+			if err != nil || absentAfter <= 0 {
+				return ERR_BAD_ABSENCE
+			}
+			if config[i].Stuck != "" || config[i].Aggregate != "" || config[i].Threshold != nil || config[i].Suppress != nil {
+				return ERR_BAD_ABSENCE
+			}
+			config[i].absentAfter = absentAfter
+		}
+
+		if err := config[i].compileCorrelate(); err != nil {
+			return err
+		}
+
+		if config[i].Retry != nil {
+			if err := config[i].Retry.compile(); err != nil {
+				return err
+			}
+		}
+
+		if err := config[i].compileHeaders(dummyTemplateData(matcher)); err != nil {
+			return err
+		}
+
+		if err := config[i].compileExpectStatus(); err != nil {
+			return err
+		}
+
+		if config[i].ExpectResponse != nil {
+			if err := config[i].ExpectResponse.compile(); err != nil {
+				return err
+			}
+		}
+
+		if config[i].Concurrency < 0 {
+			return ERR_BAD_CONCURRENCY
+		}
+
+		orderBy, err := variableIndexes(config[i].OrderBy)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ERR_BAD_ORDER_BY, err)
+		}
+		config[i].orderBy = orderBy
+
+		if err := config[i].compileClient(); err != nil {
 			return err
 		}
+
+		if config[i].Auth != nil {
+			if config[i].Token != "" {
+				return fmt.Errorf("%w: token and auth can not both be set", ERR_BAD_AUTH)
+			}
+			if err := config[i].Auth.compile(); err != nil {
+				return err
+			}
+		}
+
+		if config[i].Signing != nil {
+			if err := config[i].Signing.compile(); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -120,22 +347,34 @@ func ValidateConfig() error {
 // helpers
 func isGoodMethod(method string) error {
 	switch method {
-	case "GET", "POST":
+	case "GET", "HEAD", "OPTIONS", "POST", "PUT", "PATCH", "DELETE":
 		return nil
 	default:
 		return ERR_BAD_METHOD
 	}
 }
 
-func shouldHaveRequestBody(method, requestBody string) error {
-	if method != "GET" {
+// sendsBody checks if requests with the method carry the request body
+func sendsBody(method string) bool {
+	switch method {
+	case "POST", "PUT", "PATCH", "DELETE":
+		return true
+	}
+	return false
+}
+
+// shouldHaveRequestBody checks the body of methods which send one, it is optional for DELETE
+func shouldHaveRequestBody(method, requestBody string, matcher triggerSet) error {
+	if sendsBody(method) {
 		if requestBody == "" {
+			if method == "DELETE" {
+				return nil
+			}
 			return ERR_NO_BODY
 		}
 		// we should parse the body, to check that any included vars are valid
 		// or we'll fail at runtime when the hook is trigger
-
-		_, err := parseRequestBody(&internal.Process{}, requestBody)
+		_, err := parseRequestBody(dummyTemplateData(matcher), requestBody)
 		if err != nil {
 			return ERR_COULD_NOT_PARSE_BODY
 		}
@@ -143,20 +382,110 @@ func shouldHaveRequestBody(method, requestBody string) error {
 	return nil
 }
 
-func checkTriggers(trigger Trigger) error {
-	if trigger.TaskName == "" && trigger.Status == "" && trigger.ProcessType == "" && trigger.CreatedBy == "" && trigger.AccountId == 0 {
+// dummyTemplateData is used to check templates when validating, regex capture groups
+// are populated with empty values so they can be used
+func dummyTemplateData(matcher triggerSet) *templateData {
+	data := &templateData{
+		safeProcess: newSafeProcess(&internal.Process{}),
+		Matches:     map[string][]string{},
+		Tasks:       []state.Task{{}},
+	}
+	groups := map[string]int{}
+	matcher.captureGroups(groups)
+	fillMatches(data.Matches, groups)
+	return data
+}
+
+// fillMatches gives each regex trigger the number of values it may capture, those of a regex which did not
+// match, such as one of a list or anyOf which another value matched, are empty
+func fillMatches(matches map[string][]string, groups map[string]int) {
+	for key, n := range groups {
+		for len(matches[key]) < n {
+			matches[key] = append(matches[key], "")
+		}
+	}
+}
+
+func checkTriggers(trigger Trigger, when string) error {
+	if len(trigger) == 0 && when == "" {
 		return ERR_NO_TRIGGER
 	}
+	return nil
+}
+
+func checkEvents(events []string, progress []float64) error {
+	var hasProgress bool
+	for _, event := range events {
+		switch event {
+		case EVENT_STARTED, EVENT_COMPLETED, EVENT_FAILED, EVENT_RECOVERED:
+		case EVENT_PROGRESS:
+			hasProgress = true
+		default:
+			return ERR_BAD_EVENT
+		}
+	}
+
+	if hasProgress && len(progress) == 0 {
+		return ERR_NO_PROGRESS
+	}
+
+	for _, percent := range progress {
+		if percent <= 0 || percent > 100 {
+			return ERR_BAD_PROGRESS
+		}
+	}
+	return nil
+}
+
+// compileCorrelate finds the fields of the correlateBy variables, which the recovered event requires
+func (h *Hook) compileCorrelate() error {
+	if !h.subscribes(EVENT_RECOVERED) {
+		if len(h.CorrelateBy) > 0 {
+			return fmt.Errorf("%w: correlateBy is set without the recovered event", ERR_BAD_CORRELATE)
+		}
+		return nil
+	}
+
+	if len(h.CorrelateBy) == 0 || h.Stuck != "" || h.Aggregate != "" || h.Absence != "" {
+		return ERR_BAD_CORRELATE
+	}
+
+	correlateBy, err := variableIndexes(h.CorrelateBy)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ERR_BAD_CORRELATE, err)
+	}
+	h.correlateBy = correlateBy
+	return nil
+}
+
+// checkAggregate checks the aggregate mode, workflows are aggregated when they finish so
+// the hook can not be stuck or fire on the started and progress events
+func checkAggregate(aggregate, stuck string, events []string) error {
+	if aggregate == "" {
+		return nil
+	}
+	if aggregate != AGGREGATE_WORKFLOW || stuck != "" {
+		return ERR_BAD_AGGREGATE
+	}
+	for _, event := range events {
+		if event != EVENT_COMPLETED && event != EVENT_FAILED {
+			return ERR_BAD_AGGREGATE
+		}
+	}
+	return nil
+}
 
-	if trigger.Status == "executing" {
+// checkStatus is used when compiling the triggers to check a status trigger value, running
+// is the status of processes for started and progress events
+func checkStatus(status interface{}) error {
+	if status == "executing" {
 		return ERR_NO_EXECUTING_STATUS_TRIGGER
 	}
 
-	switch trigger.Status {
-	case "complete", "failed", "":
+	switch status {
+	case "complete", "failed", internal.EXECUTING:
 		return nil
 	default:
-		fmt.Println(trigger.Status)
 		return ERR_BAD_STATUS_TRIGGER
 	}
 }
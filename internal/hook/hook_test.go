@@ -1,6 +1,7 @@
 package hook
 
 import (
+	"errors"
 	"os"
 	"reflect"
 	"testing"
@@ -17,6 +18,7 @@ func writeTestYamlFile(t *testing.T) {
     token: faketoken1
     triggers:
       status: complete
+      zoneId: 3
 
 - webhook:
     description: test hook 2
@@ -25,7 +27,8 @@ func writeTestYamlFile(t *testing.T) {
     requestBody: '{"id": 55}'
     token: faketoken2
     triggers:
-      processType: provision`
+      processType: provision
+    when: duration > 600`
 	if err := os.WriteFile(testYamlFile, []byte(data), 0644); err != nil {
 		t.Fatalf("could not write test yaml file %+v", err)
 	}
@@ -49,7 +52,8 @@ func TestReadAndParseConfig(t *testing.T) {
 				Method:      "GET",
 				Token:       "faketoken1",
 				Triggers: Trigger{
-					Status: "complete",
+					"status": "complete",
+					"zoneId": 3,
 				},
 			},
 		},
@@ -61,8 +65,9 @@ func TestReadAndParseConfig(t *testing.T) {
 				RequestBody: "{\"id\": 55}",
 				Token:       "faketoken2",
 				Triggers: Trigger{
-					ProcessType: "provision",
+					"processType": "provision",
 				},
+				When: "duration > 600",
 			},
 		},
 	}
@@ -97,7 +102,7 @@ func TestValidateConfig(t *testing.T) {
 						Method:      "GET",
 						Token:       "faketoken1",
 						Triggers: Trigger{
-							Status: "complete",
+							"status": "complete",
 						},
 					},
 				},
@@ -114,7 +119,7 @@ func TestValidateConfig(t *testing.T) {
 						Method:      "BAD_GET",
 						Token:       "faketoken1",
 						Triggers: Trigger{
-							Status: "complete",
+							"status": "complete",
 						},
 					},
 				},
@@ -131,7 +136,7 @@ func TestValidateConfig(t *testing.T) {
 						Method:      "GET",
 						Token:       "faketoken1",
 						Triggers: Trigger{
-							Status: "complete",
+							"status": "complete",
 						},
 					},
 				},
@@ -148,7 +153,7 @@ func TestValidateConfig(t *testing.T) {
 						Method:      "GET",
 						Token:       "faketoken1",
 						Triggers: Trigger{
-							Status: "complete",
+							"status": "complete",
 						},
 					},
 				},
@@ -165,7 +170,7 @@ func TestValidateConfig(t *testing.T) {
 						Method:      "POST",
 						Token:       "faketoken1",
 						Triggers: Trigger{
-							Status: "complete",
+							"status": "complete",
 						},
 					},
 				},
@@ -183,7 +188,7 @@ func TestValidateConfig(t *testing.T) {
 						RequestBody: "{{.BadId}}",
 						Token:       "faketoken1",
 						Triggers: Trigger{
-							Status: "complete",
+							"status": "complete",
 						},
 					},
 				},
@@ -214,7 +219,7 @@ func TestValidateConfig(t *testing.T) {
 						Method:      "GET",
 						Token:       "faketoken1",
 						Triggers: Trigger{
-							Status: "executing",
+							"status": "executing",
 						},
 					},
 				},
@@ -231,13 +236,809 @@ func TestValidateConfig(t *testing.T) {
 						Method:      "GET",
 						Token:       "faketoken1",
 						Triggers: Trigger{
-							Status: "badcomplete",
+							"status": "badcomplete",
 						},
 					},
 				},
 			},
 			wantErr: ERR_BAD_STATUS_TRIGGER,
 		},
+		{
+			name: "trigger on any process variable, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"zoneId":          3,
+							"instanceName":    "web-01",
+							"jobTemplateName": "nightly",
+							"serverGroupName": "k8s",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "list, not and anyOf triggers as parsed from YAML, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"status":    "failed",
+							"accountId": []interface{}{2, 5, 9},
+							"not": map[interface{}]interface{}{
+								"createdBy": "svc-ci",
+							},
+							"anyOf": []interface{}{
+								map[interface{}]interface{}{"zoneId": 1},
+								map[interface{}]interface{}{"zoneId": 2, "taskName": "Backup"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "status trigger list includes invalid status, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"status": []interface{}{"failed", "badcomplete"},
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_STATUS_TRIGGER,
+		},
+		{
+			name: "request body uses regex capture group, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "POST",
+						RequestBody: `{"version": "{{index .Matches "taskName" 1}}"}`,
+						Triggers: Trigger{
+							"taskName": map[interface{}]interface{}{"regex": `^Deploy App v(\d+)$`},
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "request body uses capture group which does not exist, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "POST",
+						RequestBody: `{"version": "{{index .Matches "taskName" 2}}"}`,
+						Triggers: Trigger{
+							"taskName": map[interface{}]interface{}{"regex": `^Deploy App v(\d+)$`},
+						},
+					},
+				},
+			},
+			wantErr: ERR_COULD_NOT_PARSE_BODY,
+		},
+		{
+			name: "trigger is not a process variable, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"instance": "web-01",
+						},
+					},
+				},
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "trigger path has an empty part, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"cloud.": "AWS",
+						},
+					},
+				},
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "trigger path has an empty part in the middle, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"instance..name": "web-01",
+						},
+					},
+				},
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "trigger tag has an empty key, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"instance.tags.": map[interface{}]interface{}{"regex": "^prod"},
+						},
+					},
+				},
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "trigger value is wrong type, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"zoneId": "three",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "descriptions are not unique, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"status": "complete",
+						},
+					},
+				},
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"status": "failed",
+						},
+					},
+				},
+			},
+			wantErr: ERR_DUPLICATE_DESCRIPTION,
+		},
+		{
+			name: "stuck duration, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Stuck:       "90m",
+						Triggers: Trigger{
+							"processType": "ansiblePlaybook",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "stuck is not a duration, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Stuck:       "2 hours",
+						Triggers: Trigger{
+							"processType": "ansiblePlaybook",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_STUCK,
+		},
+		{
+			name: "lifecycle events with progress, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Events:      []string{"started", "progress", "completed", "failed"},
+						Progress:    []float64{50, 90},
+						Triggers: Trigger{
+							"status": "running",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "event is not recognised, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Events:      []string{"finished"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_EVENT,
+		},
+		{
+			name: "progress event without percentages, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Events:      []string{"progress"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_NO_PROGRESS,
+		},
+		{
+			name: "progress percentage out of range, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Events:      []string{"progress"},
+						Progress:    []float64{50, 150},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_PROGRESS,
+		},
+		{
+			name: "stuck hook with events, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Stuck:       "2h",
+						Events:      []string{"started"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_STUCK_WITH_EVENTS,
+		},
+		{
+			name: "aggregate workflow, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "POST",
+						RequestBody: `{"tasks": [{{range $i, $t := .Tasks}}{{if $i}},{{end}}"{{$t.TaskName}}"{{end}}]}`,
+						Aggregate:   "workflow",
+						Events:      []string{"failed"},
+						Triggers: Trigger{
+							"taskSetName": "Provision",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "aggregate is not recognised, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Aggregate:   "taskSet",
+						Triggers: Trigger{
+							"taskSetName": "Provision",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_AGGREGATE,
+		},
+		{
+			name: "aggregate workflow on started event, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Aggregate:   "workflow",
+						Events:      []string{"started"},
+						Triggers: Trigger{
+							"taskSetName": "Provision",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_AGGREGATE,
+		},
+		{
+			name: "threshold on stuck hook, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Stuck:       "2h",
+						Threshold:   &Threshold{Count: 3, Within: "30m"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_THRESHOLD,
+		},
+		{
+			name: "absence is not a duration, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Absence:     "daily",
+						Triggers: Trigger{
+							"jobTemplateName": "Nightly Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_ABSENCE,
+		},
+		{
+			name: "absence with threshold, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Absence:     "26h",
+						Threshold:   &Threshold{Count: 2, Within: "1h"},
+						Triggers: Trigger{
+							"jobTemplateName": "Nightly Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_ABSENCE,
+		},
+		{
+			name: "signing with sha512, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Signing:     &Signing{Algorithm: "sha512", Secret: "secret"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "signing without secret, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Signing:     &Signing{},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_SIGNING,
+		},
+		{
+			name: "signing with unknown algorithm, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Signing:     &Signing{Algorithm: "md5", Secret: "secret"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_SIGNING,
+		},
+		{
+			name: "headers with process variable, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Headers:     map[string]string{"X-Tenant": "{{.AccountName}}"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "headers with unknown variable, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Headers:     map[string]string{"X-Tenant": "{{.Tenant}}"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_HEADER,
+		},
+		{
+			name: "header name not valid, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Headers:     map[string]string{"X Tenant": "dozer"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_HEADER,
+		},
+		{
+			name: "PATCH with request body, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "PATCH",
+						RequestBody: `{"status": "{{.Status}}"}`,
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "no request body on PUT, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "PUT",
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_NO_BODY,
+		},
+		{
+			name: "DELETE without request body, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "DELETE",
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "expectStatus list and range, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description:  "test hook",
+						URL:          "https://testurl.com",
+						Method:       "GET",
+						ExpectStatus: []interface{}{201, "200-204"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "expectStatus range reversed, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description:  "test hook",
+						URL:          "https://testurl.com",
+						Method:       "GET",
+						ExpectStatus: "299-200",
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_EXPECT_STATUS,
+		},
+		{
+			name: "expectStatus not a status, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description:  "test hook",
+						URL:          "https://testurl.com",
+						Method:       "GET",
+						ExpectStatus: 2000,
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_EXPECT_STATUS,
+		},
+		{
+			name: "expectResponse with jsonPath, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description:    "test hook",
+						URL:            "https://testurl.com",
+						Method:         "GET",
+						ExpectResponse: &ExpectResponse{JSONPath: "$.result.status", Equals: "accepted"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "expectResponse without equals or regex, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description:    "test hook",
+						URL:            "https://testurl.com",
+						Method:         "GET",
+						ExpectResponse: &ExpectResponse{JSONPath: "$.result"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_EXPECT_RESPONSE,
+		},
+		{
+			name: "expectResponse with bad jsonPath, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description:    "test hook",
+						URL:            "https://testurl.com",
+						Method:         "GET",
+						ExpectResponse: &ExpectResponse{JSONPath: "result", Equals: "ok"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_EXPECT_RESPONSE,
+		},
+		{
+			name: "token with auth, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Token:       "BEARER xxxx",
+						Auth:        &Auth{Type: AUTH_BASIC, Username: "dozer"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_AUTH,
+		},
+		{
+			name: "orderBy with unknown variable, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						OrderBy:     []string{"instanceKey"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_ORDER_BY,
+		},
+		{
+			name: "recovered event without correlateBy, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Events:      []string{"recovered"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_CORRELATE,
+		},
+		{
+			name: "correlateBy with unknown variable, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Events:      []string{"recovered"},
+						CorrelateBy: []string{"task"},
+						Triggers: Trigger{
+							"taskName": "Backup",
+						},
+					},
+				},
+			},
+			wantErr: ERR_BAD_CORRELATE,
+		},
+		{
+			name: "when expression without triggers, should pass",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						When:        `status == "failed" && instanceName.startsWith("prod-")`,
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "when expression has typo in variable, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						When:        `stauts == "failed"`,
+					},
+				},
+			},
+			wantErr: ERR_BAD_WHEN,
+		},
+		{
+			name: "when expression does not return bool, should fail",
+			config: Hooks{
+				{
+					Hook{
+						Description: "test hook",
+						URL:         "https://testurl.com",
+						Method:      "GET",
+						Triggers: Trigger{
+							"status": "complete",
+						},
+						When: "duration",
+					},
+				},
+			},
+			wantErr: ERR_BAD_WHEN,
+		},
 
 		// Reference: https://github.com/spoonboy-io/dozer/issues/1
 		// temporary removal of validation
@@ -251,7 +1052,7 @@ func TestValidateConfig(t *testing.T) {
 							URL:         "http://testurl.com",
 							Method:      "GET",
 							Triggers: Trigger{
-								Status: "complete",
+								"status": "complete",
 							},
 						},
 					},
@@ -266,7 +1067,7 @@ func TestValidateConfig(t *testing.T) {
 			// set the package config
 			config = tc.config
 			gotErr := ValidateConfig()
-			if gotErr != tc.wantErr {
+			if !errors.Is(gotErr, tc.wantErr) {
 				t.Errorf("wanted %v got %v", tc.wantErr, gotErr)
 			}
 		})
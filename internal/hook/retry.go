@@ -0,0 +1,210 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal/deadletter"
+	"github.com/spoonboy-io/koan"
+)
+
+// DeadLetters stores deliveries which failed after all attempts, it is set by the application
+// and failed deliveries are only logged when it is nil
+var DeadLetters *deadletter.Store
+
+// retry defaults, used when the policy does not set them
+const (
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// defaultRetryStatus are the response statuses retried when the policy does not set them
+var defaultRetryStatus = []int{408, 429, 500, 502, 503, 504}
+
+// Retry is the retry policy of a hook. Attempts is the maximum number of attempts, including the first,
+// waiting Backoff before the second and doubling each time up to MaxBackoff, with jitter. Failed
+// requests are retried if no response was received or the response status is one of StatusCodes
+type Retry struct {
+	Attempts    int    `yaml:"attempts"`
+	Backoff     string `yaml:"backoff"`
+	MaxBackoff  string `yaml:"maxBackoff"`
+	StatusCodes []int  `yaml:"statusCodes"`
+
+	// backoff and maxBackoff are the parsed durations
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// compile checks the policy and parses the durations, applying defaults
+func (r *Retry) compile() error {
+	if r.Attempts < 1 {
+		return fmt.Errorf("%w: attempts should be 1 or more", ERR_BAD_RETRY)
+	}
+
+	r.backoff = defaultBackoff
+	if r.Backoff != "" {
+		backoff, err := time.ParseDuration(r.Backoff)
+		if err != nil || backoff <= 0 {
+			return fmt.Errorf("%w: backoff should be a duration such as 2s", ERR_BAD_RETRY)
+		}
+		r.backoff = backoff
+	}
+
+	r.maxBackoff = defaultMaxBackoff
+	if r.MaxBackoff != "" {
+		maxBackoff, err := time.ParseDuration(r.MaxBackoff)
+		if err != nil || maxBackoff < r.backoff {
+			return fmt.Errorf("%w: maxBackoff should be a duration no less than backoff", ERR_BAD_RETRY)
+		}
+		r.maxBackoff = maxBackoff
+	}
+
+	if len(r.StatusCodes) == 0 {
+		r.StatusCodes = defaultRetryStatus
+	}
+	for _, code := range r.StatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("%w: status code %d is not valid", ERR_BAD_RETRY, code)
+		}
+	}
+	return nil
+}
+
+// retryable checks if the request should be tried again after the error
+func (r *Retry) retryable(err error) bool {
+	var ae *assertionError
+	if errors.Is(err, context.Canceled) || errors.As(err, &ae) {
+		return false
+	}
+
+	var se *statusError
+	if !errors.As(err, &se) {
+		// no response was received
+		return true
+	}
+	for _, code := range r.StatusCodes {
+		if se.statusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// wait returns the time to wait before the next attempt, the backoff doubles with each attempt up to
+// the maximum, and a random half of it is taken off so hooks failing together don't retry together
+func (r *Retry) wait(attempt int) time.Duration {
+	backoff := r.backoff
+	for i := 1; i < attempt && backoff < r.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > r.maxBackoff {
+		backoff = r.maxBackoff
+	}
+
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// deliverWithRetry delivers the body to the webhook, retrying as set by the hook's retry policy.
+// Hooks without a policy make a single attempt. It returns the number of attempts made, attempts
+// stop with ERR_CIRCUIT_OPEN if the circuit breaker of the webhook host is open
+func deliverWithRetry(ctx context.Context, body []byte, headers map[string]string, hook *Hook, logger *koan.Logger) (int, error) {
+	attempts := 1
+	if hook.Retry != nil {
+		attempts = hook.Retry.Attempts
+	}
+
+	breaker := breakerFor(hook.URL)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		// requests are not made to a host while its circuit breaker is open
+		if !breaker.allow(logger) {
+			return attempt - 1, fmt.Errorf("%w: '%s'", ERR_CIRCUIT_OPEN, breaker.host)
+		}
+
+		err = deliver(ctx, body, headers, hook)
+		breaker.record(err, logger)
+		if err == nil {
+			return attempt, nil
+		}
+
+		if attempt == attempts || !hook.Retry.retryable(err) {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(hook.Retry.wait(attempt)):
+		}
+	}
+}
+
+// deadLetter adds a delivery which failed to the dead letter store so it can be replayed
+func deadLetter(hook *Hook, processId int, event string, body []byte, headers map[string]string, attempts int, err error, logger *koan.Logger) {
+	if DeadLetters == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	letter := deadletter.Letter{
+		Hook:      hook.Description,
+		ProcessId: processId,
+		Event:     event,
+		Method:    hook.Method,
+		URL:       hook.URL,
+		Body:      string(body),
+		Headers:   headers,
+		Attempts:  attempts,
+		Error:     err.Error(),
+		Failed:    time.Now(),
+	}
+	if err := DeadLetters.Add(letter); err != nil {
+		logger.Error("Failed to save dead letter", err)
+	}
+}
+
+// Replay delivers a dead letter again using the current configuration of its hook, with the body
+// and headers rendered when it first failed. The letter is removed from the store if it is delivered
+func Replay(ctx context.Context, id int, logger *koan.Logger) error {
+	letter, err := DeadLetters.Get(id)
+	if err != nil {
+		return err
+	}
+
+	hook := findHook(letter.Hook)
+	if hook == nil {
+		return fmt.Errorf("%w: '%s'", ERR_HOOK_NOT_FOUND, letter.Hook)
+	}
+
+	var body []byte
+	if letter.Body != "" {
+		body = []byte(letter.Body)
+	}
+
+	attempts, err := deliverWithRetry(ctx, body, letter.Headers, hook, logger)
+	if err != nil {
+		letter.Attempts += attempts
+		letter.Error = err.Error()
+		letter.Failed = time.Now()
+		if updateErr := DeadLetters.Update(letter); updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
+
+	return DeadLetters.Remove(id)
+}
+
+// findHook returns the configured hook with the description, or nil
+func findHook(description string) *Hook {
+	for i := range config {
+		if config[i].Description == description {
+			return &config[i].Hook
+		}
+	}
+	return nil
+}
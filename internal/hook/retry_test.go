@@ -0,0 +1,181 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal/deadletter"
+	"github.com/spoonboy-io/koan"
+)
+
+func Test_deliverWithRetry(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name         string
+		responses    []int
+		retry        *Retry
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"no policy, single attempt", []int{503, 200}, nil, 1, true},
+		{"retried until delivered", []int{503, 502, 200}, &Retry{Attempts: 5}, 3, false},
+		{"attempts exhausted", []int{503, 503, 503, 200}, &Retry{Attempts: 3}, 3, true},
+		{"status is not retryable", []int{404, 200}, &Retry{Attempts: 3}, 1, true},
+		{"retryable status set by policy", []int{404, 200}, &Retry{Attempts: 3, StatusCodes: []int{404}}, 2, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(tc.responses[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			hook := &Hook{Description: "test hook", URL: server.URL, Method: "GET", Retry: tc.retry}
+			if tc.retry != nil {
+				tc.retry.Backoff = "1ms"
+				if err := tc.retry.compile(); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+			}
+
+			gotAttempts, err := deliverWithRetry(ctx, nil, nil, hook, &koan.Logger{})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("wanted error %v got %v", tc.wantErr, err)
+			}
+			if gotAttempts != tc.wantAttempts || requests != tc.wantAttempts {
+				t.Errorf("wanted %d attempts got %d (%d requests)", tc.wantAttempts, gotAttempts, requests)
+			}
+		})
+	}
+}
+
+func TestRetryCompile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		retry   Retry
+		wantErr error
+	}{
+		{"defaults", Retry{Attempts: 3}, nil},
+		{"durations and status", Retry{Attempts: 3, Backoff: "2s", MaxBackoff: "30s", StatusCodes: []int{500}}, nil},
+		{"no attempts", Retry{}, ERR_BAD_RETRY},
+		{"bad backoff", Retry{Attempts: 3, Backoff: "soon"}, ERR_BAD_RETRY},
+		{"max backoff less than backoff", Retry{Attempts: 3, Backoff: "10s", MaxBackoff: "5s"}, ERR_BAD_RETRY},
+		{"bad status code", Retry{Attempts: 3, StatusCodes: []int{5000}}, ERR_BAD_RETRY},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.retry.compile(); !errors.Is(err, tc.wantErr) {
+				t.Errorf("wanted %v got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	retry := &Retry{Attempts: 10, Backoff: "1s", MaxBackoff: "5s"}
+	if err := retry.compile(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	testCases := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{9, 5 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		for i := 0; i < 20; i++ {
+			got := retry.wait(tc.attempt)
+			if got < tc.max/2 || got > tc.max {
+				t.Errorf("attempt %d wanted wait between %v and %v got %v", tc.attempt, tc.max/2, tc.max, got)
+			}
+		}
+	}
+}
+
+func TestDeadLetterAndReplay(t *testing.T) {
+	ctx := context.Background()
+	logger := &koan.Logger{}
+
+	DeadLetters = deadletter.New(filepath.Join(t.TempDir(), deadletter.FILE_NAME))
+	defer func() { DeadLetters = nil }()
+
+	status := http.StatusServiceUnavailable
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received = append(received, string(body))
+		rw.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	config = Hooks{
+		{
+			Hook{
+				Description: "retried hook",
+				URL:         server.URL,
+				Method:      "POST",
+				RequestBody: "{{.Id}} {{.Event}}",
+				Retry:       &Retry{Attempts: 2, Backoff: "1ms"},
+				Triggers: Trigger{
+					"taskName": "Backup",
+				},
+			},
+		},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
+
+	sendWebhook(ctx, &templateData{safeProcess: safeProcess{Id: 8}, Event: EVENT_FAILED}, &config[0].Hook, logger)
+
+	letters := DeadLetters.List()
+	if len(letters) != 1 {
+		t.Fatalf("wanted 1 dead letter got %d", len(letters))
+	}
+	if letters[0].Attempts != 2 || letters[0].Body != "8 failed" || letters[0].ProcessId != 8 {
+		t.Errorf("wanted dead letter with 2 attempts got %+v", letters[0])
+	}
+
+	// replay fails, the letter is kept with the attempts counted
+	if err := Replay(ctx, letters[0].Id, logger); err == nil {
+		t.Errorf("wanted replay to fail")
+	}
+	if letter, _ := DeadLetters.Get(letters[0].Id); letter.Attempts != 4 {
+		t.Errorf("wanted 4 attempts got %d", letter.Attempts)
+	}
+
+	// replay is delivered with the original body, the letter is removed
+	status = http.StatusOK
+	received = nil
+	if err := Replay(ctx, letters[0].Id, logger); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if len(received) != 1 || received[0] != "8 failed" {
+		t.Errorf("wanted replayed body got %v", received)
+	}
+	if len(DeadLetters.List()) != 0 {
+		t.Errorf("wanted dead letter removed got %v", DeadLetters.List())
+	}
+
+	if err := Replay(ctx, letters[0].Id, logger); !errors.Is(err, deadletter.ERR_NOT_FOUND) {
+		t.Errorf("wanted %v got %v", deadletter.ERR_NOT_FOUND, err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
 )
 
 // safeProcess will collect the properties we want to make available for interpolating into the JSON request body,
@@ -53,7 +54,7 @@ type safeProcess struct {
 	//Deleted              []byte
 	TaskId   int64
 	UniqueId string
-	//Percent              float64
+	Percent  float64
 	//TimerCategory        string
 	Reason        string
 	EndDate       time.Time
@@ -74,24 +75,125 @@ type safeProcess struct {
 	ProcessResult string
 	Description   string
 	EventTitle    string
+
+	// enriched from the morpheus account and user tables
+	AccountName        string
+	CreatedByEmail     string
+	CreatedByFirstName string
+	CreatedByLastName  string
+
+	// enriched from the morpheus instance, compute_zone and compute_server tables, when enabled,
+	// e.g. `{{.Instance.Tags.env}}` or `{{.Server.InternalIp}}`
+	Instance internal.Instance
+	Cloud    internal.Cloud
+	Server   internal.Server
+}
+
+// templateData is the data made available to the requestBody template. Event is the lifecycle event
+// which fired the hook and Matches holds the capture groups of regex triggers keyed by the trigger name,
+// e.g. `{{index .Matches "taskName" 1}}`. Tasks holds the tasks of a workflow for aggregating hooks,
+// Suppressed the count of events dropped for suppression summaries and LastSeen when an absence hook
+// last saw a matching process
+type templateData struct {
+	safeProcess
+	Event      string
+	Matches    map[string][]string
+	Tasks      []state.Task
+	Suppressed int
+	LastSeen   time.Time
+}
+
+// statusError is returned when the webhook responds with a status it is not expected to, so
+// the status can be checked when deciding to retry
+type statusError struct {
+	statusCode  int
+	description string
+	url         string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("Bad response (%d): Hook: %s, URL: %s", e.statusCode, e.description, e.url)
+}
+
+func fireWebhook(ctx context.Context, data *templateData, hook *Hook) error {
+	body, err := renderBody(data, hook)
+	if err != nil {
+		return err
+	}
+	headers, err := renderHeaders(data, hook)
+	if err != nil {
+		return err
+	}
+	return deliver(ctx, body, headers, hook)
+}
+
+// renderBody parses the RequestBody if required
+func renderBody(data *templateData, hook *Hook) ([]byte, error) {
+	if !sendsBody(hook.Method) || hook.RequestBody == "" {
+		return nil, nil
+	}
+
+	body, err := parseRequestBody(data, hook.RequestBody)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(body)
 }
 
-func fireWebhook(ctx context.Context, process *internal.Process, hook *Hook) error {
-	var data io.Reader
-	var err error
+// deliver makes a single request to the webhook with the rendered body and headers, which
+// are set over the default headers. Requests refused with 401 by hooks using oauth2 are made
+// once more with a new access token
+func deliver(ctx context.Context, body []byte, headers map[string]string, hook *Hook) error {
+	req, err := newRequest(ctx, body, headers, hook)
+	if err != nil {
+		return err
+	}
 
-	// parse RequestBody if required
-	if hook.Method != "GET" && hook.RequestBody != "" {
-		data, err = parseRequestBody(process, hook.RequestBody)
+	res, err := hook.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized && hook.Auth != nil && hook.Auth.Type == AUTH_OAUTH2 {
+		res.Body.Close()
+		hook.Auth.invalidate()
+
+		if req, err = newRequest(ctx, body, headers, hook); err != nil {
+			return err
+		}
+		if res, err = hook.httpClient().Do(req); err != nil {
+			return err
+		}
+	}
+	defer res.Body.Close()
+
+	if !hook.expects(res.StatusCode) {
+		return &statusError{statusCode: res.StatusCode, description: hook.Description, url: hook.URL}
+	}
+
+	if hook.ExpectResponse != nil {
+		resBody, err := io.ReadAll(io.LimitReader(res.Body, responseLimit))
 		if err != nil {
 			return err
 		}
+		if reason := hook.ExpectResponse.check(resBody); reason != "" {
+			return &assertionError{description: hook.Description, reason: reason}
+		}
 	}
 
-	// form the request, make and return any errors
-	req, err := http.NewRequest(hook.Method, hook.URL, data)
+	return nil
+}
+
+// newRequest forms the request to the webhook, with its authentication, headers and signature
+func newRequest(ctx context.Context, body []byte, headers map[string]string, hook *Hook) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(hook.Method, hook.URL, reader)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req = req.WithContext(ctx)
 	req.Header.Add("Content-Type", "application/json")
@@ -101,23 +203,29 @@ func fireWebhook(ctx context.Context, process *internal.Process, hook *Hook) err
 		req.Header.Add("Authorization", hook.Token)
 	}
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+	if hook.Auth != nil {
+		if err := hook.Auth.apply(ctx, req, hook.httpClient()); err != nil {
+			return nil, err
+		}
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("Bad response (%d): Hook: %s, URL: %s", res.StatusCode, hook.Description, hook.URL)
+	for name, value := range headers {
+		req.Header.Set(name, value)
 	}
 
-	return nil
-}
+	if hook.Signing != nil {
+		if err := hook.Signing.sign(req, body); err != nil {
+			return nil, err
+		}
+	}
 
-func parseRequestBody(process *internal.Process, body string) (io.Reader, error) {
-	var buffer bytes.Buffer
+	return req, nil
+}
 
-	safeProcess := safeProcess{
+// newSafeProcess copies the properties of process which we make available to the templates
+// and trigger expressions into a safeProcess, enriched with the account and user which created it
+func newSafeProcess(process *internal.Process) safeProcess {
+	sp := safeProcess{
 		Id:                   process.Id,
 		SubType:              process.SubType.String,
 		UpdatedById:          process.UpdatedById.Int64,
@@ -151,6 +259,7 @@ func parseRequestBody(process *internal.Process, body string) (io.Reader, error)
 		SubId:                process.SubId.Int64,
 		TaskId:               process.TaskId.Int64,
 		UniqueId:             process.UniqueId.String,
+		Percent:              process.Percent.Float64,
 		Reason:               process.Reason.String,
 		EndDate:              process.EndDate.Time,
 		Duration:             process.Duration.Int64,
@@ -172,8 +281,33 @@ func parseRequestBody(process *internal.Process, body string) (io.Reader, error)
 		EventTitle:           process.EventTitle.String,
 	}
 
+	if account, ok := internal.GetAccount(process.AccountId.Int64); ok {
+		sp.AccountName = account.Name.String
+	}
+	if user, ok := internal.GetUser(process.CreatedById.Int64); ok {
+		sp.CreatedByEmail = user.Email.String
+		sp.CreatedByFirstName = user.FirstName.String
+		sp.CreatedByLastName = user.LastName.String
+	}
+
+	if process.Instance != nil {
+		sp.Instance = *process.Instance
+	}
+	if process.Cloud != nil {
+		sp.Cloud = *process.Cloud
+	}
+	if process.Server != nil {
+		sp.Server = *process.Server
+	}
+
+	return sp
+}
+
+func parseRequestBody(data *templateData, body string) (io.Reader, error) {
+	var buffer bytes.Buffer
+
 	t := template.Must(template.New("body").Parse(body))
-	if err := t.Execute(&buffer, safeProcess); err != nil {
+	if err := t.Execute(&buffer, data); err != nil {
 		return &buffer, err
 	}
 
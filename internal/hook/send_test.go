@@ -8,13 +8,15 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/signature"
 )
 
 func Test_fireWebhook(t *testing.T) {
 	ctx := context.Background()
-	process := &internal.Process{}
+	data := &templateData{}
 	hook := &Hook{}
 
 	// test good response
@@ -24,7 +26,7 @@ func Test_fireWebhook(t *testing.T) {
 	hook.URL = server.URL
 	hook.Description = "test hook"
 
-	if err := fireWebhook(ctx, process, hook); err != nil {
+	if err := fireWebhook(ctx, data, hook); err != nil {
 		t.Errorf("fail %v", err)
 	}
 	server.Close()
@@ -36,7 +38,7 @@ func Test_fireWebhook(t *testing.T) {
 	hook.URL = server2.URL
 	hook.Description = "test hook - bad should be 404 and error"
 
-	if err := fireWebhook(ctx, process, hook); err == nil {
+	if err := fireWebhook(ctx, data, hook); err == nil {
 		t.Errorf("fail expected an error because the server did not return 200")
 	}
 	server2.Close()
@@ -52,7 +54,7 @@ func Test_fireWebhook(t *testing.T) {
 	hook.Description = "test hook - bad should be 404 and error"
 	hook.Token = wantToken
 
-	if err := fireWebhook(ctx, process, hook); err != nil {
+	if err := fireWebhook(ctx, data, hook); err != nil {
 		t.Errorf("fail %v", err)
 	}
 
@@ -60,12 +62,62 @@ func Test_fireWebhook(t *testing.T) {
 		t.Errorf("fail wanted %v, got %v", gotToken, wantToken)
 	}
 	server3.Close()
+
+	// test the request is signed
+	var gotErr error
+	server4 := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, gotErr = signature.VerifyRequest(req, "secret", time.Minute)
+		rw.Write([]byte(`ok`))
+	}))
+	hook.URL = server4.URL
+	hook.Method = "POST"
+	hook.RequestBody = `{"id": {{.Id}}}`
+	hook.Signing = &Signing{Secret: "secret"}
+	if err := hook.Signing.compile(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if err := fireWebhook(ctx, data, hook); err != nil {
+		t.Errorf("fail %v", err)
+	}
+	if gotErr != nil {
+		t.Errorf("fail wanted signed request, got %v", gotErr)
+	}
+	server4.Close()
+
+	// test headers are rendered and set over the defaults
+	var gotHeader http.Header
+	server5 := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		gotHeader = req.Header
+		rw.Write([]byte(`ok`))
+	}))
+	hook.URL = server5.URL
+	hook.Signing = nil
+	hook.Headers = map[string]string{
+		"X-Correlation-Id": "dozer-{{.Id}}",
+		"Content-Type":     "application/vnd.api+json",
+	}
+	if err := hook.compileHeaders(data); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if err := fireWebhook(ctx, &templateData{safeProcess: safeProcess{Id: 7}}, hook); err != nil {
+		t.Errorf("fail %v", err)
+	}
+	if gotHeader.Get("X-Correlation-Id") != "dozer-7" || gotHeader.Get("Content-Type") != "application/vnd.api+json" {
+		t.Errorf("fail wanted rendered headers, got %v", gotHeader)
+	}
+	if gotHeader.Get("Authorization") != wantToken {
+		t.Errorf("fail wanted token kept, got %v", gotHeader.Get("Authorization"))
+	}
+	server5.Close()
 }
 
 func Test_processRequestBody(t *testing.T) {
 	testCases := []struct {
 		name       string
 		process    *internal.Process
+		matches    map[string][]string
 		body       string
 		wantOutput string
 	}{
@@ -79,6 +131,7 @@ func Test_processRequestBody(t *testing.T) {
 				CreatedBy:       sql.NullString{String: "Test User"},
 				AccountId:       sql.NullInt64{Int64: 2},
 			},
+			nil,
 			"{{.Id}}, {{.Status}}, {{.ProcessTypeName}}, {{.TaskName}}, {{.CreatedBy}}, {{.AccountId}}",
 			"1, complete, Test Process Name, Test Task Name, Test User, 2",
 		},
@@ -96,15 +149,41 @@ func Test_processRequestBody(t *testing.T) {
 				JobTemplateId:        sql.NullInt64{Int64: 2},
 				ContainerName:        sql.NullString{String: "Test Container Name"},
 			},
+			nil,
 			"{{.Success}}, {{.CreatedByDisplayName}}, {{.DisplayName}}, {{.Input}}, {{.AppId}}, {{.Message}}, {{.RefType}}, {{.JobTemplateId}}, {{.ContainerName}}",
 			"true, Test User, Test User, Test Input, 2, Test Message, Test Ref, 2, Test Container Name",
 		},
+
+		{
+			"testing regex capture groups are interpolated",
+			&internal.Process{
+				TaskName: sql.NullString{String: "Deploy App v12"},
+			},
+			map[string][]string{
+				"taskName": {"Deploy App v12", "12"},
+			},
+			`{{.TaskName}}, {{index .Matches "taskName" 1}}`,
+			"Deploy App v12, 12",
+		},
+
+		{
+			"testing enriched instance, cloud and server are interpolated",
+			&internal.Process{
+				Instance: &internal.Instance{Name: "web-01", Plan: "Large", Tags: map[string]string{"env": "prod"}},
+				Cloud:    &internal.Cloud{Name: "AWS"},
+				Server:   &internal.Server{InternalIp: "10.0.0.5"},
+			},
+			nil,
+			"{{.Instance.Name}}, {{.Instance.Plan}}, {{.Instance.Tags.env}}, {{.Cloud.Name}}, {{.Server.InternalIp}}",
+			"web-01, Large, prod, AWS, 10.0.0.5",
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// set the package config
-			gotReader, err := parseRequestBody(tc.process, tc.body)
+			data := &templateData{safeProcess: newSafeProcess(tc.process), Matches: tc.matches}
+			gotReader, err := parseRequestBody(data, tc.body)
 			if err != nil {
 				t.Fatalf("Unexpected error %v ", err)
 			}
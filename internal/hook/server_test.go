@@ -0,0 +1,51 @@
+package hook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testServer is a webhook endpoint which records the body of each request it receives
+type testServer struct {
+	*httptest.Server
+	received chan string
+}
+
+// newTestServer starts a webhook endpoint which is closed when the test finishes
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	s := &testServer{received: make(chan string, 10)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		s.received <- string(body)
+		rw.Write([]byte(`ok`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// expectRequest fails the test unless a request with the body is received within a second
+func (s *testServer) expectRequest(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-s.received:
+		if got != want {
+			t.Errorf("wanted request %v got %v", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("wanted request %v got none", want)
+	}
+}
+
+// expectNoRequest fails the test if a request is received
+func (s *testServer) expectNoRequest(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-s.received:
+		t.Errorf("wanted no request got %v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,47 @@
+package hook
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spoonboy-io/dozer/signature"
+)
+
+// Signing configures a hook to sign its requests with an HMAC of the timestamp and body using the Secret,
+// so receivers can verify the request came from Dozer and is not a replay, see the signature package.
+// Algorithm is sha256, the default, or sha512
+type Signing struct {
+	Algorithm string `yaml:"algorithm"`
+	Secret    string `yaml:"secret"`
+}
+
+// compile checks the signing settings, applying the default algorithm
+func (s *Signing) compile() error {
+	if s.Secret == "" {
+		return fmt.Errorf("%w: secret is not set", ERR_BAD_SIGNING)
+	}
+
+	if s.Algorithm == "" {
+		s.Algorithm = signature.SHA256
+	}
+	if s.Algorithm != signature.SHA256 && s.Algorithm != signature.SHA512 {
+		return fmt.Errorf("%w: %v", ERR_BAD_SIGNING, signature.ERR_BAD_ALGORITHM)
+	}
+	return nil
+}
+
+// sign adds the signature and timestamp headers to the request, the timestamp is when the
+// request is made so retried and replayed deliveries are signed again
+func (s *Signing) sign(req *http.Request, body []byte) error {
+	now := time.Now()
+	sig, err := signature.Sign(s.Algorithm, s.Secret, now, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(signature.HEADER_SIGNATURE, sig)
+	req.Header.Set(signature.HEADER_TIMESTAMP, strconv.FormatInt(now.Unix(), 10))
+	return nil
+}
@@ -0,0 +1,59 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
+	"github.com/spoonboy-io/koan"
+)
+
+// CheckStuck is called for processes tracked as executing. Hooks which have `stuck` set will fire a
+// stuck event once for a matching process which has been running for longer than the stuck duration,
+// measured from its start date, and fire again with a completed or failed event when the process
+// finishes. Stuck events fired are recorded in state so the second event fires after a restart
+func CheckStuck(ctx context.Context, process *internal.Process, st *state.State, logger *koan.Logger) {
+	sp := newSafeProcess(process)
+
+	for i := range config {
+		hook := &config[i].Hook
+		if hook.stuckAfter == 0 {
+			continue
+		}
+
+		data := &templateData{safeProcess: sp, Event: EVENT_STUCK, Matches: map[string][]string{}}
+
+		// the process has finished, fire the second event if we reported it stuck
+		if process.Status != internal.EXECUTING {
+			if st.IsStuck(hook.Description, process.Id) {
+				st.ClearStuck(hook.Description, process.Id)
+				data.Event = finishedEvent(process)
+				sendWebhook(ctx, data, hook, logger)
+			}
+			continue
+		}
+
+		if !process.StartDate.Valid || time.Since(process.StartDate.Time) < hook.stuckAfter {
+			continue
+		}
+
+		if st.IsStuck(hook.Description, process.Id) {
+			continue
+		}
+
+		fire, err := shouldFire(data, hook)
+		if err != nil {
+			warnMsg := fmt.Sprintf("Failed to evaluate when expression (hook: '%s', process id: '%d') error: %v",
+				hook.Description, process.Id, err)
+			logger.Warn(warnMsg)
+			continue
+		}
+
+		if fire {
+			st.MarkStuck(hook.Description, process.Id)
+			sendWebhook(ctx, data, hook, logger)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package hook
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
+	"github.com/spoonboy-io/koan"
+)
+
+func TestCheckStuck(t *testing.T) {
+	ctx := context.Background()
+	logger := &koan.Logger{}
+	st := &state.State{}
+
+	server := newTestServer(t)
+
+	config = Hooks{
+		{
+			Hook{
+				Description: "stuck hook",
+				URL:         server.URL,
+				Method:      "POST",
+				RequestBody: "{{.Id}} {{.Status}}",
+				Stuck:       "2h",
+				Triggers: Trigger{
+					"taskName": "Ansible Run",
+				},
+			},
+		},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
+
+	process := &internal.Process{
+		Id:        5,
+		Status:    "running",
+		TaskName:  sql.NullString{String: "Ansible Run"},
+		StartDate: sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true},
+	}
+
+	// running, not yet stuck
+	CheckStuck(ctx, process, st, logger)
+	server.expectNoRequest(t)
+
+	// running longer than stuck, fires once
+	process.StartDate.Time = time.Now().Add(-3 * time.Hour)
+	CheckStuck(ctx, process, st, logger)
+	server.expectRequest(t, "5 running")
+	if !st.IsStuck("stuck hook", 5) {
+		t.Errorf("process should be recorded as stuck in state")
+	}
+
+	CheckStuck(ctx, process, st, logger)
+	server.expectNoRequest(t)
+
+	// a different task which is stuck does not match the triggers
+	other := &internal.Process{
+		Id:        6,
+		Status:    "running",
+		TaskName:  sql.NullString{String: "Other"},
+		StartDate: sql.NullTime{Time: time.Now().Add(-3 * time.Hour), Valid: true},
+	}
+	CheckStuck(ctx, other, st, logger)
+	server.expectNoRequest(t)
+
+	// finished, fires the second event
+	process.Status = "complete"
+	CheckStuck(ctx, process, st, logger)
+	server.expectRequest(t, "5 complete")
+	if st.IsStuck("stuck hook", 5) {
+		t.Errorf("process should be cleared from state once finished")
+	}
+
+	// finished process not reported stuck does not fire
+	other.Status = "complete"
+	CheckStuck(ctx, other, st, logger)
+	server.expectNoRequest(t)
+}
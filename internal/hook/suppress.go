@@ -0,0 +1,86 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
+	"github.com/spoonboy-io/koan"
+)
+
+// compile parses the window duration and the key and summary templates, executing them
+// against data so any variables included are checked
+func (s *Suppress) compile(data *templateData) error {
+	window, err := time.ParseDuration(s.Window)
+	if err != nil || window <= 0 {
+		return fmt.Errorf("%w: window should be a duration such as 10m", ERR_BAD_SUPPRESS)
+	}
+	s.window = window
+
+	if s.Key == "" {
+		return fmt.Errorf("%w: key is required", ERR_BAD_SUPPRESS)
+	}
+	key, err := template.New("key").Parse(s.Key)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ERR_BAD_SUPPRESS, err)
+	}
+	if err := key.Execute(&bytes.Buffer{}, data); err != nil {
+		return fmt.Errorf("%w: %v", ERR_BAD_SUPPRESS, err)
+	}
+	s.key = key
+
+	if s.Summary != "" {
+		summary, err := template.New("summary").Parse(s.Summary)
+		if err != nil {
+			return fmt.Errorf("%w: summary %v", ERR_BAD_SUPPRESS, err)
+		}
+		if err := summary.Execute(&bytes.Buffer{}, data); err != nil {
+			return fmt.Errorf("%w: summary %v", ERR_BAD_SUPPRESS, err)
+		}
+	}
+	return nil
+}
+
+// suppressed reports if the event should be dropped as the hook has fired for the same key
+// within the suppression window
+func (h *Hook) suppressed(data *templateData, process *internal.Process, st *state.State) (bool, error) {
+	var key bytes.Buffer
+	if err := h.Suppress.key.Execute(&key, data); err != nil {
+		return false, err
+	}
+
+	return st.Suppress(h.Description, h.Description+"|"+key.String(), time.Now().Add(h.Suppress.window), process), nil
+}
+
+// SendSuppressed is called each poll to close suppression windows. Hooks with a summary set fire it for
+// windows in which events were dropped, with the last dropped process and the count as {{.Suppressed}}
+func SendSuppressed(ctx context.Context, st *state.State, logger *koan.Logger) {
+	for _, sup := range st.CloseSuppressions() {
+		if sup.Count == 0 || sup.Process == nil {
+			continue
+		}
+
+		for i := range config {
+			hook := &config[i].Hook
+			if hook.Description != sup.Hook || hook.Suppress == nil || hook.Suppress.Summary == "" {
+				continue
+			}
+
+			// the summary is sent as the request body in place of the hook's own
+			summary := *hook
+			summary.RequestBody = hook.Suppress.Summary
+
+			data := &templateData{
+				safeProcess: newSafeProcess(sup.Process),
+				Event:       finishedEvent(sup.Process),
+				Matches:     map[string][]string{},
+				Suppressed:  sup.Count,
+			}
+			sendWebhook(ctx, data, &summary, logger)
+		}
+	}
+}
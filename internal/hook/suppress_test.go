@@ -0,0 +1,109 @@
+package hook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
+	"github.com/spoonboy-io/koan"
+)
+
+func TestSuppress(t *testing.T) {
+	ctx := context.Background()
+	logger := &koan.Logger{}
+	st := &state.State{}
+
+	server := newTestServer(t)
+
+	config = Hooks{
+		{
+			Hook{
+				Description: "flapping task",
+				URL:         server.URL,
+				Method:      "POST",
+				RequestBody: "{{.Id}} failed",
+				Suppress: &Suppress{
+					Key:     "{{.InstanceId}}-{{.TaskName}}",
+					Window:  "10m",
+					Summary: "{{.Id}} suppressed {{.Suppressed}}",
+				},
+				Triggers: Trigger{
+					"status": "failed",
+				},
+			},
+		},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
+
+	newProcess := func(id int, instanceId int64) *internal.Process {
+		return &internal.Process{
+			Id:         id,
+			Status:     "failed",
+			TaskName:   sql.NullString{String: "Health Check"},
+			InstanceId: sql.NullInt64{Int64: instanceId},
+		}
+	}
+
+	// the first event fires and opens the window
+	CheckProcess(ctx, newProcess(1, 5), st, logger)
+	server.expectRequest(t, "1 failed")
+
+	// repeats are dropped, other keys fire
+	CheckProcess(ctx, newProcess(2, 5), st, logger)
+	CheckProcess(ctx, newProcess(3, 5), st, logger)
+	server.expectNoRequest(t)
+	CheckProcess(ctx, newProcess(4, 6), st, logger)
+	server.expectRequest(t, "4 failed")
+
+	// the window is still open
+	SendSuppressed(ctx, st, logger)
+	server.expectNoRequest(t)
+
+	// close the windows, a summary is sent only where events were dropped
+	for _, sup := range st.Suppressed {
+		sup.Until = time.Now().Add(-time.Second)
+	}
+	SendSuppressed(ctx, st, logger)
+	server.expectRequest(t, "3 suppressed 2")
+	server.expectNoRequest(t)
+
+	if len(st.Suppressed) != 0 {
+		t.Errorf("wanted suppression windows removed from state got %v", st.Suppressed)
+	}
+
+	// a new window is opened
+	CheckProcess(ctx, newProcess(5, 5), st, logger)
+	server.expectRequest(t, "5 failed")
+}
+
+func TestSuppressCompile(t *testing.T) {
+	testCases := []struct {
+		name     string
+		suppress Suppress
+		wantErr  error
+	}{
+		{"key and window", Suppress{Key: "{{.InstanceId}}-{{.TaskName}}", Window: "10m"}, nil},
+		{"with summary", Suppress{Key: "{{.InstanceId}}", Window: "1h", Summary: `{"count": {{.Suppressed}}}`}, nil},
+		{"no key", Suppress{Window: "10m"}, ERR_BAD_SUPPRESS},
+		{"bad window", Suppress{Key: "{{.InstanceId}}", Window: "ten minutes"}, ERR_BAD_SUPPRESS},
+		{"unknown key variable", Suppress{Key: "{{.Zone}}", Window: "10m"}, ERR_BAD_SUPPRESS},
+		{"unknown summary variable", Suppress{Key: "{{.InstanceId}}", Window: "10m", Summary: "{{.Count}}"}, ERR_BAD_SUPPRESS},
+		{"summary does not parse", Suppress{Key: "{{.InstanceId}}", Window: "10m", Summary: `{"n": {{.Suppressed}`}, ERR_BAD_SUPPRESS},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.suppress.compile(dummyTemplateData(triggerSet{}))
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("wanted %v got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
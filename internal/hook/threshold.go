@@ -0,0 +1,77 @@
+package hook
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal/state"
+)
+
+// compile parses the within duration and finds the fields of the by variables
+func (t *Threshold) compile() error {
+	within, err := time.ParseDuration(t.Within)
+	if t.Count < 1 || err != nil || within <= 0 {
+		return ERR_BAD_THRESHOLD
+	}
+	t.within = within
+
+	by, err := variableIndexes(t.By)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ERR_BAD_THRESHOLD, err)
+	}
+	t.by = by
+	return nil
+}
+
+// reachesThreshold counts the matching process in state and reports if the threshold has been reached,
+// in which case the count starts again. The process end date is used as the time it was seen, so counts
+// are unaffected by when the process was polled
+func (h *Hook) reachesThreshold(data *templateData, st *state.State) bool {
+	at := data.EndDate
+	if at.IsZero() {
+		at = time.Now()
+	}
+	key := variablesKey(h.Description, h.Threshold.By, h.Threshold.by, &data.safeProcess)
+	return st.AddOccurrence(key, at, h.Threshold.within, h.Threshold.Count)
+}
+
+// PruneThresholds is called each poll to drop threshold counts which are no longer within the window
+// of their hook
+func PruneThresholds(st *state.State) {
+	windows := map[string]time.Duration{}
+	for i := range config {
+		if config[i].Threshold != nil {
+			windows[config[i].Description] = config[i].Threshold.within
+		}
+	}
+	st.PruneOccurrences(windows, time.Now())
+}
+
+// variableIndexes finds the safeProcess fields of process variables named as they are in the triggers
+func variableIndexes(names []string) ([]int, error) {
+	var indexes []int
+	for _, key := range names {
+		name := key
+		if key == processTypeKey {
+			name = "processTypeName"
+		}
+		index, ok := processFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable '%s'", key)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// variablesKey identifies a record in state for the hook and the values of the process variables
+func variablesKey(description string, names []string, indexes []int, sp *safeProcess) string {
+	key := []string{description}
+	v := reflect.ValueOf(sp).Elem()
+	for i, index := range indexes {
+		key = append(key, fmt.Sprintf("%s=%v", names[i], v.Field(index).Interface()))
+	}
+	return strings.Join(key, "|")
+}
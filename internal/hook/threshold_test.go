@@ -0,0 +1,120 @@
+package hook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
+	"github.com/spoonboy-io/koan"
+)
+
+func TestThreshold(t *testing.T) {
+	ctx := context.Background()
+	logger := &koan.Logger{}
+	st := &state.State{}
+
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received = append(received, string(body))
+		rw.Write([]byte(`ok`))
+	}))
+	defer server.Close()
+
+	config = Hooks{
+		{
+			Hook{
+				Description: "repeated failures",
+				URL:         server.URL,
+				Method:      "POST",
+				RequestBody: "{{.Id}} {{.AccountId}}",
+				Threshold: &Threshold{
+					Count:  3,
+					Within: "30m",
+					By:     []string{"accountId"},
+				},
+				Triggers: Trigger{
+					"taskName": "Backup",
+					"status":   "failed",
+				},
+			},
+		},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
+
+	start := time.Now().Add(-2 * time.Hour)
+	newProcess := func(id int, accountId int64, status string, after time.Duration) *internal.Process {
+		return &internal.Process{
+			Id:        id,
+			Status:    status,
+			TaskName:  sql.NullString{String: "Backup"},
+			AccountId: sql.NullInt64{Int64: accountId},
+			EndDate:   sql.NullTime{Time: start.Add(after), Valid: true},
+		}
+	}
+
+	processes := []*internal.Process{
+		newProcess(1, 2, "failed", 0),
+		newProcess(2, 2, "failed", 10*time.Minute),
+		// other account and completed processes are not counted
+		newProcess(3, 5, "failed", 15*time.Minute),
+		newProcess(4, 2, "complete", 16*time.Minute),
+		// 1 is no longer within 30 minutes
+		newProcess(5, 2, "failed", 35*time.Minute),
+		newProcess(6, 2, "failed", 38*time.Minute),
+		// count starts again once fired
+		newProcess(7, 2, "failed", 40*time.Minute),
+		newProcess(8, 5, "failed", 41*time.Minute),
+		newProcess(9, 5, "failed", 42*time.Minute),
+	}
+	for _, process := range processes {
+		CheckProcess(ctx, process, st, logger)
+	}
+
+	want := []string{"6 2", "9 5"}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("wanted %v got %v", want, received)
+	}
+
+	wantThresholds := []string{"repeated failures|accountId=2"}
+	var gotThresholds []string
+	for key := range st.Thresholds {
+		gotThresholds = append(gotThresholds, key)
+	}
+	if !reflect.DeepEqual(gotThresholds, wantThresholds) {
+		t.Errorf("wanted %v got %v", wantThresholds, gotThresholds)
+	}
+}
+
+func TestThresholdCompile(t *testing.T) {
+	testCases := []struct {
+		name      string
+		threshold Threshold
+		wantErr   error
+	}{
+		{"by process type", Threshold{Count: 2, Within: "1h", By: []string{"processType", "zoneId"}}, nil},
+		{"no by variables", Threshold{Count: 2, Within: "1h"}, nil},
+		{"no count", Threshold{Within: "1h"}, ERR_BAD_THRESHOLD},
+		{"bad within", Threshold{Count: 2, Within: "an hour"}, ERR_BAD_THRESHOLD},
+		{"unknown by variable", Threshold{Count: 2, Within: "1h", By: []string{"account"}}, ERR_BAD_THRESHOLD},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.threshold.compile(); !errors.Is(err, tc.wantErr) {
+				t.Errorf("wanted %v got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,584 @@
+package hook
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+)
+
+// processTypeKey is the trigger key for process type. The YAML uses the process type code
+// which is swapped for the name when matching, as the name is what is recorded against the process
+const processTypeKey = "processType"
+
+// exitCodeKey is the trigger key for exit code, which is recorded as text but compared as a number
+const exitCodeKey = "exitCode"
+
+// instanceTagsKey is shorthand for the path to the enriched instance tags, e.g. `instanceTags.env: prod`
+const instanceTagsKey = "instanceTags"
+
+// processFields maps the lowerCamel name of each safeProcess field to its field index
+var processFields = func() map[string]int {
+	fields := map[string]int{}
+	t := reflect.TypeOf(safeProcess{})
+	for i := 0; i < t.NumField(); i++ {
+		fields[lowerFirst(t.Field(i).Name)] = i
+	}
+	return fields
+}()
+
+// trigger keys which group other triggers rather than match a process variable
+const (
+	notKey   = "not"
+	anyOfKey = "anyOf"
+)
+
+// triggerSet is the compiled form of a Trigger. All conditions must match, none of the not
+// conditions may match and, if any are set, at least one of the anyOf trigger sets must match
+type triggerSet struct {
+	conditions []condition
+	not        []condition
+	anyOf      []triggerSet
+}
+
+// condition is the compiled form of a single trigger. A list of values in the YAML is matched if
+// any one of the values match, each value is made up of one or more operands which must all match
+type condition struct {
+	key    string
+	index  []int
+	mapKey string
+	limit  int
+	values [][]operand
+}
+
+// operators which can be used in the YAML to match a trigger other than by equality
+// e.g. `taskName: {regex: "^Deploy App v\\d+$"}`, `instanceName: {glob: "prod-*"}` or `duration: {gt: 900000}`
+const (
+	opEquals         = "eq"
+	opNotEquals      = "ne"
+	opGreater        = "gt"
+	opGreaterOrEqual = "gte"
+	opLess           = "lt"
+	opLessOrEqual    = "lte"
+	opRegex          = "regex"
+	opGlob           = "glob"
+	opContains       = "contains"
+)
+
+// contentFields hold text reported by the process which may be very large, only the
+// first internal.CONTENT_MATCH_LIMIT bytes are inspected when matching them
+var contentFields = map[string]bool{
+	"output":  true,
+	"error":   true,
+	"message": true,
+}
+
+// operand is a trigger value which has been coerced to the type of the safeProcess field
+// it is compared with, or a compiled pattern for regex and glob operators
+type operand struct {
+	op      string
+	value   interface{}
+	pattern *regexp.Regexp
+}
+
+// compileTriggers validates the trigger keys against the safeProcess fields and converts
+// each trigger value to the type of the field it will be compared with
+func compileTriggers(trigger Trigger) (triggerSet, error) {
+	var set triggerSet
+	for _, key := range sortedKeys(trigger) {
+		value := trigger[key]
+
+		switch key {
+		case notKey:
+			not, err := toMap(value)
+			if err != nil {
+				return set, fmt.Errorf("%w: '%s' %v", ERR_BAD_TRIGGER_VALUE, key, err)
+			}
+			for _, k := range sortedKeys(not) {
+				c, err := compileCondition(k, not[k])
+				if err != nil {
+					return set, err
+				}
+				set.not = append(set.not, c)
+			}
+
+		case anyOfKey:
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				return set, fmt.Errorf("%w: '%s' should be a list of triggers", ERR_BAD_TRIGGER_VALUE, key)
+			}
+			for _, item := range list {
+				anyOf, err := toMap(item)
+				if err != nil {
+					return set, fmt.Errorf("%w: '%s' %v", ERR_BAD_TRIGGER_VALUE, key, err)
+				}
+				anyOfSet, err := compileTriggers(Trigger(anyOf))
+				if err != nil {
+					return set, err
+				}
+				set.anyOf = append(set.anyOf, anyOfSet)
+			}
+
+		default:
+			c, err := compileCondition(key, value)
+			if err != nil {
+				return set, err
+			}
+			set.conditions = append(set.conditions, c)
+		}
+	}
+	return set, nil
+}
+
+// compileCondition validates a single trigger key and its value, or list of values
+func compileCondition(key string, value interface{}) (condition, error) {
+	index, mapKey, t, err := resolveField(key)
+	if err != nil {
+		return condition{}, err
+	}
+
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	if len(values) == 0 {
+		return condition{}, fmt.Errorf("%w: '%s' has an empty list", ERR_BAD_TRIGGER_VALUE, key)
+	}
+
+	c := condition{key: key, index: index, mapKey: mapKey}
+	if contentFields[key] {
+		c.limit = internal.CONTENT_MATCH_LIMIT
+	}
+	for _, v := range values {
+		operands, err := compileOperands(key, v, t)
+		if err != nil {
+			return condition{}, err
+		}
+		c.values = append(c.values, operands)
+	}
+
+	return c, nil
+}
+
+// resolveField finds the safeProcess field a trigger key is matched against. Keys of enriched data are a
+// path, e.g. `cloud.name` or `instance.tags.env`, where the part following a map is the map key
+func resolveField(key string) ([]int, string, reflect.Type, error) {
+	path := strings.Split(key, ".")
+	switch path[0] {
+	case processTypeKey:
+		path[0] = "processTypeName"
+	case instanceTagsKey:
+		path = append([]string{"instance", "tags"}, path[1:]...)
+	}
+
+	// each part of the path, including a map key, is needed
+	for _, part := range path {
+		if part == "" {
+			return nil, "", nil, fmt.Errorf("%w: '%s'", ERR_UNKNOWN_TRIGGER, key)
+		}
+	}
+
+	index, ok := processFields[path[0]]
+	if !ok {
+		return nil, "", nil, fmt.Errorf("%w: '%s'", ERR_UNKNOWN_TRIGGER, key)
+	}
+	indexes := []int{index}
+	t := reflect.TypeOf(safeProcess{}).Field(index).Type
+
+	for i := 1; i < len(path); i++ {
+		switch {
+		case t.Kind() == reflect.Map && i == len(path)-1:
+			return indexes, path[i], t.Elem(), nil
+
+		case t.Kind() == reflect.Struct && t != reflect.TypeOf(time.Time{}):
+			field, ok := t.FieldByName(strings.ToUpper(path[i][:1]) + path[i][1:])
+			if !ok || path[i] != lowerFirst(field.Name) {
+				return nil, "", nil, fmt.Errorf("%w: '%s'", ERR_UNKNOWN_TRIGGER, key)
+			}
+			indexes = append(indexes, field.Index...)
+			t = field.Type
+
+		default:
+			return nil, "", nil, fmt.Errorf("%w: '%s'", ERR_UNKNOWN_TRIGGER, key)
+		}
+	}
+
+	// the path should lead to a value, not the struct or map holding them
+	if t.Kind() == reflect.Map || (t.Kind() == reflect.Struct && t != reflect.TypeOf(time.Time{})) {
+		return nil, "", nil, fmt.Errorf("%w: '%s' needs a path such as '%s.name'", ERR_UNKNOWN_TRIGGER, key, key)
+	}
+	return indexes, "", t, nil
+}
+
+// compileOperands compiles a single trigger value, which is either a value to be compared
+// for equality or a map of operators and their values
+func compileOperands(key string, value interface{}, t reflect.Type) ([]operand, error) {
+	if _, ok := value.(map[interface{}]interface{}); !ok {
+		if _, ok := value.(map[string]interface{}); !ok {
+			if key == "status" {
+				if err := checkStatus(value); err != nil {
+					return nil, err
+				}
+			}
+
+			coerced, err := coerce(value, t)
+			if err != nil {
+				return nil, fmt.Errorf("%w: '%s' %v", ERR_BAD_TRIGGER_VALUE, key, err)
+			}
+			return []operand{{op: opEquals, value: coerced}}, nil
+		}
+	}
+
+	ops, err := toMap(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: '%s' %v", ERR_BAD_TRIGGER_VALUE, key, err)
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("%w: '%s' has no operator", ERR_BAD_TRIGGER_VALUE, key)
+	}
+
+	var operands []operand
+	for _, op := range sortedKeys(ops) {
+		switch op {
+		case opEquals, opNotEquals:
+			if key == "status" {
+				if err := checkStatus(ops[op]); err != nil {
+					return nil, err
+				}
+			}
+
+			coerced, err := coerce(ops[op], t)
+			if err != nil {
+				return nil, fmt.Errorf("%w: '%s' %v", ERR_BAD_TRIGGER_VALUE, key, err)
+			}
+			operands = append(operands, operand{op: op, value: coerced})
+
+		case opGreater, opGreaterOrEqual, opLess, opLessOrEqual:
+			// text is compared as a number as exitCode is recorded as text
+			switch t.Kind() {
+			case reflect.Int, reflect.Int64, reflect.Float64, reflect.String:
+			default:
+				return nil, fmt.Errorf("%w: '%s' %s can only compare numbers", ERR_BAD_TRIGGER_VALUE, key, op)
+			}
+
+			coerced, err := coerce(ops[op], reflect.TypeOf(float64(0)))
+			if err != nil {
+				return nil, fmt.Errorf("%w: '%s' %v", ERR_BAD_TRIGGER_VALUE, key, err)
+			}
+			operands = append(operands, operand{op: op, value: coerced})
+
+		case opContains:
+			text, ok := ops[op].(string)
+			if !ok || t.Kind() != reflect.String {
+				return nil, fmt.Errorf("%w: '%s' %s can only match text", ERR_BAD_TRIGGER_VALUE, key, op)
+			}
+			operands = append(operands, operand{op: op, value: text})
+
+		case opRegex, opGlob:
+			expr, ok := ops[op].(string)
+			if !ok || t.Kind() != reflect.String {
+				return nil, fmt.Errorf("%w: '%s' %s can only match text", ERR_BAD_TRIGGER_VALUE, key, op)
+			}
+
+			if op == opGlob {
+				expr = globToRegex(expr)
+			}
+
+			pattern, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: '%s' %v", ERR_BAD_TRIGGER_VALUE, key, err)
+			}
+			operands = append(operands, operand{op: op, pattern: pattern})
+
+		default:
+			return nil, fmt.Errorf("%w: '%s' operator '%s' is not recognised", ERR_BAD_TRIGGER_VALUE, key, op)
+		}
+	}
+
+	return operands, nil
+}
+
+// match checks the process against the trigger set, capture groups of regex triggers
+// which matched are added to matches
+func (ts triggerSet) match(sp *safeProcess, matches map[string][]string) bool {
+	for _, c := range ts.conditions {
+		if !c.match(sp, matches) {
+			return false
+		}
+	}
+
+	for _, c := range ts.not {
+		if c.match(sp, nil) {
+			return false
+		}
+	}
+
+	if len(ts.anyOf) == 0 {
+		return true
+	}
+
+	for _, anyOf := range ts.anyOf {
+		anyOfMatches := map[string][]string{}
+		if anyOf.match(sp, anyOfMatches) {
+			for k, v := range anyOfMatches {
+				if matches != nil {
+					matches[k] = v
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// match compares the condition values with the process field
+func (c condition) match(sp *safeProcess, matches map[string][]string) bool {
+	v := reflect.ValueOf(sp).Elem().FieldByIndex(c.index)
+	if c.mapKey != "" {
+		// a tag which is not set is matched as empty
+		v = v.MapIndex(reflect.ValueOf(c.mapKey))
+		if !v.IsValid() {
+			v = reflect.Zero(reflect.TypeOf(""))
+		}
+	}
+	got := v.Interface()
+	if text, ok := got.(string); ok && c.limit > 0 && len(text) > c.limit {
+		got = text[:c.limit]
+	}
+	for _, operands := range c.values {
+		if c.matchOperands(got, operands, matches) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOperands checks all operands of a value match the process field
+func (c condition) matchOperands(got interface{}, operands []operand, matches map[string][]string) bool {
+	var captured []string
+	for _, o := range operands {
+		switch o.op {
+		case opEquals, opNotEquals:
+			want := o.value
+			if c.key == exitCodeKey && o.op == opNotEquals {
+				// an exit code which is not set can not be compared, so does not match
+				n, ok := toFloat(got)
+				w, wantOk := toFloat(want)
+				if !ok || !wantOk || n == w {
+					return false
+				}
+				continue
+			}
+			if c.key == processTypeKey {
+				// config uses code we need to search on name, if the code is not found
+				// we compare with what was set in the config
+				processTypeName, err := getProcessTypeName(want.(string))
+				if err == nil {
+					want = processTypeName
+				}
+			}
+			if (got == want) != (o.op == opEquals) {
+				return false
+			}
+
+		case opGreater, opGreaterOrEqual, opLess, opLessOrEqual:
+			n, ok := toFloat(got)
+			if !ok || !compare(n, o.op, o.value.(float64)) {
+				return false
+			}
+
+		case opRegex:
+			captured = o.pattern.FindStringSubmatch(got.(string))
+			if captured == nil {
+				return false
+			}
+
+		case opGlob:
+			if !o.pattern.MatchString(got.(string)) {
+				return false
+			}
+
+		case opContains:
+			if !strings.Contains(got.(string), o.value.(string)) {
+				return false
+			}
+		}
+	}
+
+	if captured != nil && matches != nil {
+		matches[c.key] = captured
+	}
+	return true
+}
+
+// captureGroups records the regex triggers and the number of values they capture
+// so the requestBody can be checked with the capture groups it may use
+func (ts triggerSet) captureGroups(groups map[string]int) {
+	for _, c := range ts.conditions {
+		for _, operands := range c.values {
+			for _, o := range operands {
+				if o.op == opRegex && o.pattern.NumSubexp()+1 > groups[c.key] {
+					groups[c.key] = o.pattern.NumSubexp() + 1
+				}
+			}
+		}
+	}
+	for _, anyOf := range ts.anyOf {
+		anyOf.captureGroups(groups)
+	}
+}
+
+// compare applies a numeric comparison operator
+func compare(got float64, op string, want float64) bool {
+	switch op {
+	case opGreater:
+		return got > want
+	case opGreaterOrEqual:
+		return got >= want
+	case opLess:
+		return got < want
+	case opLessOrEqual:
+		return got <= want
+	}
+	return false
+}
+
+// toFloat converts a process field value to a number for comparison, text which is
+// not a number, such as an empty exitCode, can not be compared
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// globToRegex converts a glob where `*` matches any text and `?` any single character to a regex
+func globToRegex(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// toMap converts a nested YAML map, for `not`, `anyOf` and operators, to a map with string keys
+func toMap(value interface{}) (map[string]interface{}, error) {
+	switch v := value.(type) {
+	case Trigger:
+		return v, nil
+	case map[string]interface{}:
+		return v, nil
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, item := range v {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v is not a trigger name", k)
+			}
+			m[key] = item
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("%v is not a set of triggers", value)
+	}
+}
+
+// sortedKeys returns the trigger keys in order so triggers are always compiled, and errors reported, consistently
+func sortedKeys(trigger map[string]interface{}) []string {
+	keys := make([]string, 0, len(trigger))
+	for k := range trigger {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// coerce converts a value parsed from the YAML to the type of a safeProcess field
+// so `zoneId: "3"` and `exitCode: 0` are both acceptable
+func coerce(value interface{}, t reflect.Type) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.String:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int, int64, float64, bool:
+			return fmt.Sprint(v), nil
+		}
+
+	case reflect.Int, reflect.Int64:
+		var n int64
+		switch v := value.(type) {
+		case int:
+			n = int64(v)
+		case int64:
+			n = v
+		case float64:
+			if v != float64(int64(v)) {
+				return nil, fmt.Errorf("%v is not a whole number", v)
+			}
+			n = int64(v)
+		case string:
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%v is not a number", v)
+			}
+			n = parsed
+		default:
+			return nil, fmt.Errorf("%v is not a number", v)
+		}
+		if t.Kind() == reflect.Int {
+			return int(n), nil
+		}
+		return n, nil
+
+	case reflect.Float64:
+		switch v := value.(type) {
+		case int:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		case string:
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%v is not a number", v)
+			}
+			return parsed, nil
+		}
+
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%v is not true or false", v)
+			}
+			return parsed, nil
+		}
+	}
+
+	return nil, fmt.Errorf("can not match %v against %v", value, t)
+}
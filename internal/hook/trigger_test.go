@@ -0,0 +1,354 @@
+package hook
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/spoonboy-io/dozer/internal"
+)
+
+func Test_compileTriggers(t *testing.T) {
+	testCases := []struct {
+		name      string
+		trigger   Trigger
+		wantValue map[string]interface{}
+		wantErr   error
+	}{
+		{
+			name: "values are coerced to the field types",
+			trigger: Trigger{
+				"zoneId":       "3",
+				"id":           7,
+				"exitCode":     0,
+				"success":      "true",
+				"instanceName": "web-01",
+			},
+			wantValue: map[string]interface{}{
+				"zoneId":       []interface{}{int64(3)},
+				"id":           []interface{}{7},
+				"exitCode":     []interface{}{"0"},
+				"success":      []interface{}{true},
+				"instanceName": []interface{}{"web-01"},
+			},
+		},
+		{
+			name: "process type is matched against process type name",
+			trigger: Trigger{
+				"processType": "localWorkflow",
+			},
+			wantValue: map[string]interface{}{
+				"processType": []interface{}{"localWorkflow"},
+			},
+		},
+		{
+			name: "list values are coerced",
+			trigger: Trigger{
+				"accountId": []interface{}{2, "5", 9},
+			},
+			wantValue: map[string]interface{}{
+				"accountId": []interface{}{int64(2), int64(5), int64(9)},
+			},
+		},
+		{
+			name: "empty list, should fail",
+			trigger: Trigger{
+				"accountId": []interface{}{},
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "bad value in list, should fail",
+			trigger: Trigger{
+				"accountId": []interface{}{2, "five"},
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "bad status in list, should fail",
+			trigger: Trigger{
+				"status": []interface{}{"failed", "executing"},
+			},
+			wantErr: ERR_NO_EXECUTING_STATUS_TRIGGER,
+		},
+		{
+			name: "unknown key in not block, should fail",
+			trigger: Trigger{
+				"not": Trigger{"user": "svc-ci"},
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "not block is not a set of triggers, should fail",
+			trigger: Trigger{
+				"not": "svc-ci",
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "anyOf is not a list, should fail",
+			trigger: Trigger{
+				"anyOf": Trigger{"accountId": 2},
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "unknown key in anyOf, should fail",
+			trigger: Trigger{
+				"anyOf": []interface{}{
+					Trigger{"accountId": 2},
+					Trigger{"account": 3},
+				},
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "regex and glob operators compile",
+			trigger: Trigger{
+				"taskName":     map[interface{}]interface{}{"regex": "^Deploy App v\\d+$"},
+				"instanceName": []interface{}{map[interface{}]interface{}{"glob": "prod-*"}, "web-01"},
+			},
+			wantValue: map[string]interface{}{
+				"taskName":     []interface{}{nil},
+				"instanceName": []interface{}{nil, "web-01"},
+			},
+		},
+		{
+			name: "comparison operators compile",
+			trigger: Trigger{
+				"duration": map[interface{}]interface{}{"gt": 900000},
+				"exitCode": map[interface{}]interface{}{"ne": 0},
+				"percent":  map[interface{}]interface{}{"gte": "50", "lt": 100},
+			},
+			wantValue: map[string]interface{}{
+				"duration": []interface{}{float64(900000)},
+				"exitCode": []interface{}{"0"},
+				"percent":  []interface{}{float64(50), float64(100)},
+			},
+		},
+		{
+			name: "comparison on bool field, should fail",
+			trigger: Trigger{
+				"success": map[interface{}]interface{}{"gt": 0},
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "comparison with text, should fail",
+			trigger: Trigger{
+				"duration": map[interface{}]interface{}{"lt": "ten"},
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "not equal with bad status, should fail",
+			trigger: Trigger{
+				"status": map[interface{}]interface{}{"ne": "executing"},
+			},
+			wantErr: ERR_NO_EXECUTING_STATUS_TRIGGER,
+		},
+		{
+			name: "contains operator compiles",
+			trigger: Trigger{
+				"output": map[interface{}]interface{}{"contains": "WARNING"},
+			},
+			wantValue: map[string]interface{}{
+				"output": []interface{}{"WARNING"},
+			},
+		},
+		{
+			name: "contains on numeric field, should fail",
+			trigger: Trigger{
+				"duration": map[interface{}]interface{}{"contains": "9"},
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "bad regex, should fail",
+			trigger: Trigger{
+				"taskName": map[interface{}]interface{}{"regex": "^Deploy (App"},
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "regex on numeric field, should fail",
+			trigger: Trigger{
+				"accountId": map[interface{}]interface{}{"regex": "^2"},
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "unknown operator, should fail",
+			trigger: Trigger{
+				"taskName": map[interface{}]interface{}{"like": "Deploy%"},
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "paths into enriched data compile",
+			trigger: Trigger{
+				"instanceTags.env": "prod",
+				"instance.plan":    "Large",
+				"cloud.name":       []interface{}{"AWS", "Azure"},
+				"server.id":        "12",
+			},
+			wantValue: map[string]interface{}{
+				"instanceTags.env": []interface{}{"prod"},
+				"instance.plan":    []interface{}{"Large"},
+				"cloud.name":       []interface{}{"AWS", "Azure"},
+				"server.id":        []interface{}{int64(12)},
+			},
+		},
+		{
+			name: "enriched data without a path, should fail",
+			trigger: Trigger{
+				"cloud": "AWS",
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "unknown path into enriched data, should fail",
+			trigger: Trigger{
+				"instance.region": "eu",
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "path past a tag, should fail",
+			trigger: Trigger{
+				"instance.tags.env.name": "prod",
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "unknown key, should fail",
+			trigger: Trigger{
+				"zone": 3,
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "exported field name is not accepted, should fail",
+			trigger: Trigger{
+				"ZoneId": 3,
+			},
+			wantErr: ERR_UNKNOWN_TRIGGER,
+		},
+		{
+			name: "string for numeric field, should fail",
+			trigger: Trigger{
+				"accountId": "two",
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "fraction for numeric field, should fail",
+			trigger: Trigger{
+				"accountId": 2.5,
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+		{
+			name: "time fields can not be matched, should fail",
+			trigger: Trigger{
+				"startDate": "2022-06-01",
+			},
+			wantErr: ERR_BAD_TRIGGER_VALUE,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			set, err := compileTriggers(tc.trigger)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("wanted %v got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			gotValue := map[string]interface{}{}
+			for _, c := range set.conditions {
+				var values []interface{}
+				for _, operands := range c.values {
+					for _, o := range operands {
+						values = append(values, o.value)
+					}
+				}
+				gotValue[c.key] = values
+			}
+			if !reflect.DeepEqual(gotValue, tc.wantValue) {
+				t.Errorf("wanted %v got %v", tc.wantValue, gotValue)
+			}
+		})
+	}
+}
+
+func Test_globToRegex(t *testing.T) {
+	testCases := []struct {
+		glob string
+		text string
+		want bool
+	}{
+		{"prod-*", "prod-web-01", true},
+		{"prod-*", "dev-web-01", false},
+		{"web-0?", "web-01", true},
+		{"web-0?", "web-010", false},
+		{"db.*.local", "db.eu.local", true},
+		{"db.*.local", "dbxeuxlocal", false},
+		{"(test)", "(test)", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.glob+" "+tc.text, func(t *testing.T) {
+			got := regexp.MustCompile(globToRegex(tc.glob)).MatchString(tc.text)
+			if got != tc.want {
+				t.Errorf("wanted %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_contentLimit(t *testing.T) {
+	set, err := compileTriggers(Trigger{
+		"output": map[interface{}]interface{}{"contains": "WARNING"},
+		"error":  map[interface{}]interface{}{"regex": "Exception"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	padding := strings.Repeat("x", internal.CONTENT_MATCH_LIMIT)
+	testCases := []struct {
+		name    string
+		process safeProcess
+		want    bool
+	}{
+		{
+			"matches within the limit",
+			safeProcess{Output: "ok\nWARNING: disk\n" + padding, Error: "NullPointerException"},
+			true,
+		},
+		{
+			"output beyond the limit is not inspected",
+			safeProcess{Output: padding + "WARNING: disk", Error: "NullPointerException"},
+			false,
+		},
+		{
+			"error beyond the limit is not inspected",
+			safeProcess{Output: "WARNING: disk", Error: padding + "NullPointerException"},
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := set.match(&tc.process, nil)
+			if got != tc.want {
+				t.Errorf("wanted %v got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
+	"github.com/spoonboy-io/koan"
+)
+
+// AGGREGATE_WORKFLOW is the aggregate mode in which a hook fires once for a workflow, listing its tasks
+const AGGREGATE_WORKFLOW = "workflow"
+
+// workflowHoldLimit is how long the tasks of a workflow are held without the workflow process finishing
+// before they are dropped, so workflows which finished while Dozer was not running are not held forever
+const workflowHoldLimit = 24 * time.Hour
+
+// CheckWorkflow is called for each process which is no longer executing. If any hooks aggregate workflows, task
+// processes are held in state and the workflow process is recorded as finished, to be sent by SendWorkflows once
+// all processes from the poll have been seen
+func CheckWorkflow(process *internal.Process, st *state.State) {
+	if !aggregating() || process.TaskSetId.Int64 == 0 {
+		return
+	}
+
+	key := workflowKey(process)
+	if process.TaskId.Int64 > 0 {
+		st.HoldTask(key, state.Task{
+			Id:        process.Id,
+			TaskId:    process.TaskId.Int64,
+			TaskName:  process.TaskName.String,
+			Status:    process.Status,
+			Duration:  process.Duration.Int64,
+			ExitCode:  process.ExitCode.String,
+			StartDate: process.StartDate.Time,
+			EndDate:   process.EndDate.Time,
+		})
+		return
+	}
+
+	st.FinishWorkflow(key, process)
+}
+
+// SendWorkflows checks finished workflows against the hooks which aggregate workflows, firing with the
+// workflow process and all of its tasks available in the template as {{.Tasks}}. Workflows are removed
+// from state once checked
+func SendWorkflows(ctx context.Context, st *state.State, logger *koan.Logger) {
+	for key, wf := range st.Workflows {
+		if wf.Process == nil {
+			if time.Since(wf.Updated) > workflowHoldLimit {
+				st.DeleteWorkflow(key)
+			}
+			continue
+		}
+
+		event := finishedEvent(wf.Process)
+		sp := newSafeProcess(wf.Process)
+		for i := range config {
+			hook := &config[i].Hook
+			if hook.Aggregate != AGGREGATE_WORKFLOW || !hook.subscribes(event) {
+				continue
+			}
+
+			data := &templateData{safeProcess: sp, Event: event, Matches: map[string][]string{}, Tasks: wf.Tasks}
+			fire, err := shouldFire(data, hook)
+			if err != nil {
+				warnMsg := fmt.Sprintf("Failed to evaluate when expression (hook: '%s', process id: '%d') error: %v",
+					hook.Description, wf.Process.Id, err)
+				logger.Warn(warnMsg)
+				continue
+			}
+
+			if fire {
+				sendWebhook(ctx, data, hook, logger)
+			}
+		}
+		st.DeleteWorkflow(key)
+	}
+}
+
+// workflowKey identifies a workflow run, its processes share the task set, the instance or server
+// keeps concurrent runs of the same workflow against different targets apart
+func workflowKey(process *internal.Process) string {
+	return fmt.Sprintf("%d/%d/%d", process.TaskSetId.Int64, process.InstanceId.Int64, process.ServerId.Int64)
+}
+
+// aggregating reports if any hook aggregates workflows, if none do tasks need not be held
+func aggregating() bool {
+	for i := range config {
+		if config[i].Aggregate == AGGREGATE_WORKFLOW {
+			return true
+		}
+	}
+	return false
+}
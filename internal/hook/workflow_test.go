@@ -0,0 +1,91 @@
+package hook
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
+	"github.com/spoonboy-io/dozer/internal/state"
+	"github.com/spoonboy-io/koan"
+)
+
+func TestWorkflow(t *testing.T) {
+	ctx := context.Background()
+	logger := &koan.Logger{}
+	st := &state.State{}
+
+	server := newTestServer(t)
+
+	config = Hooks{
+		{
+			Hook{
+				Description: "workflow hook",
+				URL:         server.URL,
+				Method:      "POST",
+				RequestBody: "{{.Id}} {{.Event}}{{range .Tasks}} {{.TaskName}}:{{.Status}}:{{.Duration}}:{{.ExitCode}}{{end}}",
+				Aggregate:   "workflow",
+				Triggers: Trigger{
+					"taskSetName": "Provision",
+				},
+			},
+		},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer func() { config = nil }()
+
+	newProcess := func(id int, taskId int64, taskName, status string) *internal.Process {
+		return &internal.Process{
+			Id:          id,
+			Status:      status,
+			TaskSetId:   sql.NullInt64{Int64: 3, Valid: true},
+			TaskSetName: sql.NullString{String: "Provision", Valid: true},
+			TaskId:      sql.NullInt64{Int64: taskId, Valid: taskId > 0},
+			TaskName:    sql.NullString{String: taskName},
+			InstanceId:  sql.NullInt64{Int64: 12, Valid: true},
+			Duration:    sql.NullInt64{Int64: int64(id * 100)},
+			ExitCode:    sql.NullString{String: "0"},
+		}
+	}
+
+	// aggregating hooks do not fire per process
+	task := newProcess(11, 7, "Install", "complete")
+	CheckProcess(ctx, task, st, logger)
+	CheckWorkflow(task, st)
+	SendWorkflows(ctx, st, logger)
+	server.expectNoRequest(t)
+
+	// the parent process is seen before the last task in the same poll
+	CheckWorkflow(newProcess(10, 0, "", "failed"), st)
+	CheckWorkflow(newProcess(12, 8, "Configure", "failed"), st)
+	SendWorkflows(ctx, st, logger)
+	server.expectRequest(t, "10 failed Install:complete:1100:0 Configure:failed:1200:0")
+
+	if len(st.Workflows) != 0 {
+		t.Errorf("wanted workflows removed from state got %v", st.Workflows)
+	}
+
+	// tasks of a workflow run against another instance are held apart
+	other := newProcess(20, 7, "Install", "complete")
+	other.InstanceId.Int64 = 13
+	CheckWorkflow(other, st)
+	CheckWorkflow(newProcess(21, 0, "", "complete"), st)
+	SendWorkflows(ctx, st, logger)
+	server.expectRequest(t, "21 completed")
+
+	// tasks held without the workflow finishing are dropped after the hold limit
+	if len(st.Workflows) != 1 {
+		t.Fatalf("wanted 1 workflow held got %d", len(st.Workflows))
+	}
+	for _, wf := range st.Workflows {
+		wf.Updated = time.Now().Add(-workflowHoldLimit - time.Minute)
+	}
+	SendWorkflows(ctx, st, logger)
+	server.expectNoRequest(t)
+	if len(st.Workflows) != 0 {
+		t.Errorf("wanted stale workflow removed from state got %v", st.Workflows)
+	}
+}
@@ -2,10 +2,30 @@ package internal
 
 import (
 	"database/sql"
+	"sync"
 )
 
 const (
 	POLL_INTERVAL = 5
+	// ABSENCE_INTERVAL is the number of seconds between checks of absence hook deadlines
+	ABSENCE_INTERVAL = 60
+	// DIRECTORY_REFRESH_INTERVAL is the number of seconds between refreshes of the account and user caches
+	DIRECTORY_REFRESH_INTERVAL = 300
+	// EXECUTING is the status morpheus records for a process which is running
+	EXECUTING = "running"
+	// CONTENT_MATCH_LIMIT is the number of bytes of process output, error and message
+	// which content triggers will inspect, so a large output can't hold up checking
+	CONTENT_MATCH_LIMIT = 64 * 1024
+	// STATE_OUTPUT_LIMIT is the number of bytes of process output kept for a process saved in the state file
+	STATE_OUTPUT_LIMIT = 64 * 1024
+	// DELIVERY_WORKERS is the number of webhook deliveries made at once
+	DELIVERY_WORKERS = 10
+	// QUEUE_LIMIT is the number of deliveries which can be queued before polling waits for them to be made
+	QUEUE_LIMIT = 10000
+	// BREAKER_FAILURES is the number of consecutive failed requests to a host which open its circuit breaker
+	BREAKER_FAILURES = 5
+	// BREAKER_COOLDOWN is the number of seconds a circuit breaker stays open before a request is let through
+	BREAKER_COOLDOWN = 60
 )
 
 // ProcessType is a struct to represent a morpheus process type
@@ -21,6 +41,87 @@ type ProcessType struct {
 // so we can use code in the YAML config but look up against name in the process table
 var ProcessTypes map[string]string
 
+// Instance is a struct to represent the morpheus instance a process ran against, it is looked up
+// from the instance table, with its plan, tags and labels, when enrichment is enabled
+type Instance struct {
+	Id          int64
+	Name        string
+	DisplayName string
+	Plan        string
+	Tags        map[string]string
+	Labels      []string
+}
+
+// Cloud is a struct to represent the morpheus cloud (compute_zone) a process ran in
+type Cloud struct {
+	Id   int64
+	Name string
+	Code string
+}
+
+// Server is a struct to represent the morpheus server (compute_server) a process ran on
+type Server struct {
+	Id         int64
+	Name       string
+	Hostname   string
+	InternalIp string
+	ExternalIp string
+	ZoneId     int64
+}
+
+// Account is a struct to represent a morpheus account (tenant)
+type Account struct {
+	Id   int64          `db:"id"`
+	Name sql.NullString `db:"name"`
+}
+
+// User is a struct to represent a morpheus user
+type User struct {
+	Id        int64          `db:"id"`
+	Username  sql.NullString `db:"username"`
+	Email     sql.NullString `db:"email"`
+	FirstName sql.NullString `db:"first_name"`
+	LastName  sql.NullString `db:"last_name"`
+}
+
+// accounts and users cache the morpheus account and user tables by id, they are refreshed
+// while processes are checked so are accessed through the functions below
+var (
+	directoryMu sync.RWMutex
+	accounts    map[int64]Account
+	users       map[int64]User
+)
+
+// SetAccounts replaces the account cache
+func SetAccounts(a map[int64]Account) {
+	directoryMu.Lock()
+	defer directoryMu.Unlock()
+	accounts = a
+}
+
+// GetAccount looks up an account in the cache by id
+func GetAccount(id int64) (Account, bool) {
+	directoryMu.RLock()
+	defer directoryMu.RUnlock()
+	account, ok := accounts[id]
+	return account, ok
+}
+
+// SetUsers replaces the user cache
+func SetUsers(u map[int64]User) {
+	directoryMu.Lock()
+	defer directoryMu.Unlock()
+	users = u
+}
+
+// GetUser looks up a user in the cache by id
+func GetUser(id int64) (User, bool) {
+	directoryMu.RLock()
+	defer directoryMu.RUnlock()
+	user, ok := users[id]
+	return user, ok
+}
+
 // Process is a struct to represent a morpheus process and all the possible
 // information reported by morpheus about the process
 type Process struct {
@@ -46,7 +147,7 @@ type Process struct {
 	JobTemplateId        sql.NullInt64   `db:"job_template_id"`
 	ContainerName        sql.NullString  `db:"container_name"`
 	Output               sql.NullString  `db:"output"`
-	ApiKey               sql.NullString  `db:"api_key"`
+	ApiKey               sql.NullString  `db:"api_key" json:"-"`
 	AccountId            sql.NullInt64   `db:"account_id"`
 	StatusEta            sql.NullInt64   `db:"status_eta"`
 	TimerSubCategory     sql.NullString  `db:"timer_sub_category"`
@@ -67,7 +168,7 @@ type Process struct {
 	EndDate              sql.NullTime    `db:"end_date"`
 	Duration             sql.NullInt64   `db:"duration"`
 	InstanceName         sql.NullString  `db:"instance_name"`
-	StartDate            sql.NullTime    `db:"start_date""`
+	StartDate            sql.NullTime    `db:"start_date"`
 	ZoneId               sql.NullInt64   `db:"zone_id"`
 	InputFormat          sql.NullString  `db:"input_format"`
 	ServerId             sql.NullInt64   `db:"server_id"`
@@ -82,4 +183,10 @@ type Process struct {
 	ProcessResult        sql.NullString  `db:"process_result"`
 	Description          sql.NullString  `db:"description"`
 	EventTitle           sql.NullString  `db:"event_title"`
+
+	// Instance, Cloud and Server are not columns of the process table, they are set when
+	// processes are enriched and are nil otherwise
+	Instance *Instance `db:"-"`
+	Cloud    *Cloud    `db:"-"`
+	Server   *Server   `db:"-"`
 }
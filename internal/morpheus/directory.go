@@ -0,0 +1,65 @@
+package morpheus
+
+import (
+	"database/sql"
+
+	"github.com/spoonboy-io/dozer/internal"
+)
+
+// GetAccounts is used to cache the morpheus accounts (tenants) by id so their names are available
+// to the triggers and templates, it is called periodically so changes are picked up
+func GetAccounts(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, name FROM account;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	accounts := map[int64]internal.Account{}
+	for rows.Next() {
+		var account internal.Account
+		err := rows.Scan(&account.Id, &account.Name)
+		if err != nil {
+			return err
+		}
+
+		accounts[account.Id] = account
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// we will keep the data in the internal namespace
+	internal.SetAccounts(accounts)
+
+	return nil
+}
+
+// GetUsers is used to cache the morpheus users by id so their details are available
+// to the triggers and templates, it is called periodically so changes are picked up
+func GetUsers(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, username, email, first_name, last_name FROM `user`;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	users := map[int64]internal.User{}
+	for rows.Next() {
+		var user internal.User
+		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName)
+		if err != nil {
+			return err
+		}
+
+		users[user.Id] = user
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// we will keep the data in the internal namespace
+	internal.SetUsers(users)
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package morpheus_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/spoonboy-io/dozer/internal"
+
+	"github.com/spoonboy-io/dozer/internal/morpheus"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetAccounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	defer internal.SetAccounts(nil)
+
+	// add some mock rows
+	rows := sqlmock.NewRows([]string{"id", "name"}).
+		AddRow(1, "Master").
+		AddRow(2, "Acme")
+
+	mock.ExpectQuery("SELECT id, name FROM account;").WillReturnRows(rows)
+
+	if err := morpheus.GetAccounts(db); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// test to see if they are available in the internal namespace
+	got, ok := internal.GetAccount(2)
+	want := internal.Account{Id: 2, Name: sql.NullString{String: "Acme", Valid: true}}
+	if !ok || got != want {
+		t.Errorf("failed got %v wanted %v", got, want)
+	}
+
+	if _, ok := internal.GetAccount(3); ok {
+		t.Errorf("failed found account which does not exist")
+	}
+
+	// check expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	defer internal.SetUsers(nil)
+
+	// add some mock rows
+	rows := sqlmock.NewRows([]string{"id", "username", "email", "first_name", "last_name"}).
+		AddRow(1, "admin", "admin@acme.com", "Ada", "Admin").
+		AddRow(7, "ops", "ops@acme.com", nil, nil)
+
+	mock.ExpectQuery("SELECT id, username, email, first_name, last_name FROM `user`;").WillReturnRows(rows)
+
+	if err := morpheus.GetUsers(db); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// test to see if they are available in the internal namespace
+	got, ok := internal.GetUser(7)
+	want := internal.User{
+		Id:       7,
+		Username: sql.NullString{String: "ops", Valid: true},
+		Email:    sql.NullString{String: "ops@acme.com", Valid: true},
+	}
+	if !ok || got != want {
+		t.Errorf("failed got %v wanted %v", got, want)
+	}
+
+	// check expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetAccountsRowError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	defer internal.SetAccounts(nil)
+
+	// the connection is lost part way through the rows
+	rows := sqlmock.NewRows([]string{"id", "name"}).
+		AddRow(1, "Master").
+		AddRow(2, "Acme").
+		RowError(1, errors.New("connection lost"))
+
+	mock.ExpectQuery("SELECT id, name FROM account;").WillReturnRows(rows).RowsWillBeClosed()
+
+	if err := morpheus.GetAccounts(db); err == nil {
+		t.Errorf("failed wanted error for row which could not be read")
+	}
+	if _, ok := internal.GetAccount(1); ok {
+		t.Errorf("failed accounts cached from incomplete rows")
+	}
+
+	// the rows are closed so the connection is returned to the pool
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
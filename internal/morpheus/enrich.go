@@ -0,0 +1,138 @@
+package morpheus
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/spoonboy-io/dozer/internal"
+)
+
+// Enrichment enables the lookup of the instance, cloud and server a process ran against, it is
+// optional as it adds queries for each process checked
+var Enrichment bool
+
+// Enrich looks up the instance, cloud and server of the process so they are available to the triggers
+// and templates. The server is found from the process, or its container, and the cloud from the
+// process, or its server. Rows which are not found are left nil
+func Enrich(db *sql.DB, process *internal.Process) error {
+	if process.InstanceId.Int64 != 0 {
+		instance, err := getInstance(db, process.InstanceId.Int64)
+		if err != nil {
+			return err
+		}
+		process.Instance = instance
+	}
+
+	serverId := process.ServerId.Int64
+	if serverId == 0 && process.ContainerId.Int64 != 0 {
+		var containerServerId sql.NullInt64
+		err := db.QueryRow("SELECT server_id FROM container WHERE id = ?;", process.ContainerId.Int64).Scan(&containerServerId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		serverId = containerServerId.Int64
+	}
+
+	if serverId != 0 {
+		server, err := getServer(db, serverId)
+		if err != nil {
+			return err
+		}
+		process.Server = server
+	}
+
+	zoneId := process.ZoneId.Int64
+	if zoneId == 0 && process.Server != nil {
+		zoneId = process.Server.ZoneId
+	}
+
+	if zoneId != 0 {
+		cloud, err := getCloud(db, zoneId)
+		if err != nil {
+			return err
+		}
+		process.Cloud = cloud
+	}
+
+	return nil
+}
+
+func getInstance(db *sql.DB, id int64) (*internal.Instance, error) {
+	var name, displayName, plan, labels sql.NullString
+	err := db.QueryRow(`SELECT i.name, i.display_name, sp.name, i.labels FROM instance i
+LEFT JOIN service_plan sp ON sp.id = i.plan_id WHERE i.id = ?;`, id).Scan(&name, &displayName, &plan, &labels)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	instance := &internal.Instance{
+		Id:          id,
+		Name:        name.String,
+		DisplayName: displayName.String,
+		Plan:        plan.String,
+		Tags:        map[string]string{},
+	}
+
+	// labels are recorded as a comma separated list
+	for _, label := range strings.Split(labels.String, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			instance.Labels = append(instance.Labels, label)
+		}
+	}
+
+	rows, err := db.Query(`SELECT mt.name, mt.value FROM metadata_tag mt
+INNER JOIN instance_metadata_tag imt ON imt.metadata_tag_id = mt.id WHERE imt.instance_metadata_id = ?;`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tagName, tagValue sql.NullString
+		if err := rows.Scan(&tagName, &tagValue); err != nil {
+			return nil, err
+		}
+		instance.Tags[tagName.String] = tagValue.String
+	}
+
+	return instance, rows.Err()
+}
+
+func getServer(db *sql.DB, id int64) (*internal.Server, error) {
+	var name, hostname, internalIp, externalIp sql.NullString
+	var zoneId sql.NullInt64
+	err := db.QueryRow("SELECT name, hostname, internal_ip, external_ip, zone_id FROM compute_server WHERE id = ?;", id).
+		Scan(&name, &hostname, &internalIp, &externalIp, &zoneId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &internal.Server{
+		Id:         id,
+		Name:       name.String,
+		Hostname:   hostname.String,
+		InternalIp: internalIp.String,
+		ExternalIp: externalIp.String,
+		ZoneId:     zoneId.Int64,
+	}, nil
+}
+
+func getCloud(db *sql.DB, id int64) (*internal.Cloud, error) {
+	var name, code sql.NullString
+	err := db.QueryRow("SELECT name, code FROM compute_zone WHERE id = ?;", id).Scan(&name, &code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &internal.Cloud{Id: id, Name: name.String, Code: code.String}, nil
+}
@@ -0,0 +1,100 @@
+package morpheus_test
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/spoonboy-io/dozer/internal"
+
+	"github.com/spoonboy-io/dozer/internal/morpheus"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEnrich(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT i.name, i.display_name, sp.name, i.labels FROM instance i").WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "display_name", "plan", "labels"}).
+			AddRow("web-01", "Web 01", "Large", "web, critical"))
+	mock.ExpectQuery("SELECT mt.name, mt.value FROM metadata_tag mt").WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "value"}).
+			AddRow("env", "prod").
+			AddRow("team", "ops"))
+	mock.ExpectQuery("SELECT server_id FROM container WHERE id = ?").WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"server_id"}).AddRow(12))
+	mock.ExpectQuery("SELECT name, hostname, internal_ip, external_ip, zone_id FROM compute_server WHERE id = ?").WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "hostname", "internal_ip", "external_ip", "zone_id"}).
+			AddRow("web-01", "web-01.acme.com", "10.0.0.5", nil, 3))
+	mock.ExpectQuery("SELECT name, code FROM compute_zone WHERE id = ?").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "code"}).AddRow("AWS", "aws-eu"))
+
+	process := &internal.Process{
+		Id:          1,
+		InstanceId:  sql.NullInt64{Int64: 4, Valid: true},
+		ContainerId: sql.NullInt64{Int64: 9, Valid: true},
+	}
+	if err := morpheus.Enrich(db, process); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	wantInstance := &internal.Instance{
+		Id:          4,
+		Name:        "web-01",
+		DisplayName: "Web 01",
+		Plan:        "Large",
+		Tags:        map[string]string{"env": "prod", "team": "ops"},
+		Labels:      []string{"web", "critical"},
+	}
+	if !reflect.DeepEqual(process.Instance, wantInstance) {
+		t.Errorf("failed got %+v wanted %+v", process.Instance, wantInstance)
+	}
+
+	wantServer := &internal.Server{Id: 12, Name: "web-01", Hostname: "web-01.acme.com", InternalIp: "10.0.0.5", ZoneId: 3}
+	if !reflect.DeepEqual(process.Server, wantServer) {
+		t.Errorf("failed got %+v wanted %+v", process.Server, wantServer)
+	}
+
+	wantCloud := &internal.Cloud{Id: 3, Name: "AWS", Code: "aws-eu"}
+	if !reflect.DeepEqual(process.Cloud, wantCloud) {
+		t.Errorf("failed got %+v wanted %+v", process.Cloud, wantCloud)
+	}
+
+	// check expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestEnrichNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT name, hostname, internal_ip, external_ip, zone_id FROM compute_server WHERE id = ?").WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "hostname", "internal_ip", "external_ip", "zone_id"}))
+
+	process := &internal.Process{
+		Id:       1,
+		ServerId: sql.NullInt64{Int64: 12, Valid: true},
+	}
+	if err := morpheus.Enrich(db, process); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if process.Instance != nil || process.Server != nil || process.Cloud != nil {
+		t.Errorf("failed wanted no enrichment got %+v %+v %+v", process.Instance, process.Server, process.Cloud)
+	}
+
+	// check expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -17,15 +17,16 @@ import (
 )
 
 const (
-	EXECUTING = "running"
+	EXECUTING = internal.EXECUTING
 )
 
 // GetProcesses polls the database for processes higher than the store latestProcessId
-// if the process is found to be executing it will be tracked, otherwise it is passed on for
-// checking against the webhook configuration
+// if the process is found to be executing it will be tracked and checked against hooks for the
+// started event, otherwise it is passed on for checking against the webhook configuration. Processes are
+// checked before the poll position is updated, so webhooks which fire are queued before it moves past them
 func GetProcesses(ctx context.Context, db *sql.DB, st *state.State, logger *koan.Logger) error {
 	//rows, err := db.Query("SELECT * FROM process where id > ?;", st.LastPollProcessId)
-	rows, err := db.Query(easyQuery("> ?"), st.LastPollProcessId)
+	rows, err := db.Query(easyQuery("> ? ORDER BY id"), st.LastPollProcessId)
 	if err != nil {
 		return err
 	}
@@ -38,12 +39,21 @@ func GetProcesses(ctx context.Context, db *sql.DB, st *state.State, logger *koan
 			return err
 		}
 
+		// optionally look up the instance, cloud and server of the process
+		if Enrichment {
+			if err := Enrich(db, &process); err != nil {
+				logger.Warn(fmt.Sprintf("Failed to enrich process (process id: '%d') error: %v", process.Id, err))
+			}
+		}
+
 		// track executing processes
 		if process.Status == EXECUTING {
 			st.ExecutingProcesses = append(st.ExecutingProcesses, process.Id)
+			hook.CheckStarted(ctx, &process, st, logger)
 		} else {
 			// status is complete or failed so compare row to hook configuration
-			go hook.CheckProcess(ctx, &process, logger)
+			hook.CheckProcess(ctx, &process, st, logger)
+			hook.CheckWorkflow(&process, st)
 		}
 		lastProcessId = process.Id
 	}
@@ -58,8 +68,9 @@ func GetProcesses(ctx context.Context, db *sql.DB, st *state.State, logger *koan
 }
 
 // CheckExecuting uses state to obtain processes being tracked as 'executing', it performs
-// an SQL query which checks their status. If found to be no longer in the executing state the
-// process is passed on for checking against the webhook configuration and is no longer tracked in state
+// an SQL query which checks their status. If still executing, changes in percent are checked against
+// hooks for the progress event. If found to be no longer in the executing state the process is passed
+// on for checking against the webhook configuration and is no longer tracked in state
 func CheckExecuting(ctx context.Context, db *sql.DB, st *state.State, logger *koan.Logger) error {
 	if len(st.ExecutingProcesses) == 0 {
 		// nothing to do
@@ -73,7 +84,7 @@ func CheckExecuting(ctx context.Context, db *sql.DB, st *state.State, logger *ko
 	}
 	processList := strings.Join(strList, ",")
 	//query := fmt.Sprintf("SELECT * FROM process where id in (%s);", processList)
-	query := fmt.Sprintf(easyQuery("in (%s)"), processList)
+	query := fmt.Sprintf(easyQuery("in (%s) ORDER BY id"), processList)
 	rows, err := db.Query(query)
 	if err != nil {
 		return err
@@ -85,9 +96,25 @@ func CheckExecuting(ctx context.Context, db *sql.DB, st *state.State, logger *ko
 		if err != nil {
 			return err
 		}
-		if process.Status != EXECUTING {
+
+		// optionally look up the instance, cloud and server of the process
+		if Enrichment {
+			if err := Enrich(db, &process); err != nil {
+				logger.Warn(fmt.Sprintf("Failed to enrich process (process id: '%d') error: %v", process.Id, err))
+			}
+		}
+		// check for hooks which fire on stuck processes, and when they finish
+		hook.CheckStuck(ctx, &process, st, logger)
+
+		if process.Status == EXECUTING {
+			// check for hooks which fire as the process progresses
+			if lastPercent := st.SetProgress(process.Id, process.Percent.Float64); process.Percent.Float64 > lastPercent {
+				hook.CheckProgress(ctx, &process, lastPercent, st, logger)
+			}
+		} else {
 			// status is complete or failed so compare row to hook configuration
-			go hook.CheckProcess(ctx, &process, logger)
+			hook.CheckProcess(ctx, &process, st, logger)
+			hook.CheckWorkflow(&process, st)
 			// delete from state
 			st.DeleteProcessFromState(process.Id)
 		}
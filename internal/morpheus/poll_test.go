@@ -139,6 +139,14 @@ func TestCheckExecuting(t *testing.T) {
 		t.Errorf("failed got %v wanted %v", gotSt.ExecutingProcesses, wantSt.ExecutingProcesses)
 	}
 
+	// check progress is tracked only for the executing process
+	if _, ok := gotSt.Progress[4]; !ok {
+		t.Errorf("failed progress not recorded for executing process")
+	}
+	if _, ok := gotSt.Progress[2]; ok {
+		t.Errorf("failed progress recorded for process which is no longer executing")
+	}
+
 	// check expectations
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -0,0 +1,316 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const FILE_NAME = "dozer.queue"
+
+// the log is compacted once it holds more than compactRecords records and compactRatio
+// times the records needed for the pending deliveries, so it does not grow while deliveries
+// are held, such as for an endpoint which is down
+const (
+	compactRecords = 1000
+	compactRatio   = 2
+)
+
+// operations recorded in the log
+const (
+	opAdd  = "add"
+	opDone = "done"
+)
+
+// Delivery is a webhook delivery waiting to be made, it holds the rendered request body and headers so it is
+// sent as it would have been when the process was checked. Deliveries with an OrderKey are made one at a time,
+// in process id order, with others with the same key
+type Delivery struct {
+	Id        uint64            `json:"id"`
+	Hook      string            `json:"hook"`
+	ProcessId int               `json:"processId"`
+	Event     string            `json:"event"`
+	Body      string            `json:"body"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	OrderKey  string            `json:"orderKey,omitempty"`
+	Queued    time.Time         `json:"queued"`
+}
+
+// record is a line of the log, deliveries are added and marked done
+type record struct {
+	Op       string    `json:"op"`
+	Id       uint64    `json:"id"`
+	Delivery *Delivery `json:"delivery,omitempty"`
+}
+
+// Queue is a durable queue of deliveries backed by an append only log. Each change is written and synced to
+// the log before it is made, so deliveries which were queued but not done are recovered when it is opened
+type Queue struct {
+	path    string
+	file    *os.File
+	lastId  uint64
+	records int
+	pending map[uint64]Delivery
+	notify  chan struct{}
+	room    chan struct{}
+	mu      sync.Mutex
+}
+
+// Open reads the log at path, recovering deliveries which are not done, and compacts it so it
+// holds only those deliveries
+func Open(path string) (*Queue, error) {
+	q := &Queue{
+		path:    path,
+		pending: map[uint64]Delivery{},
+		notify:  make(chan struct{}, 1),
+		room:    make(chan struct{}, 1),
+	}
+
+	if err := q.read(); err != nil {
+		return nil, err
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// read replays the log, a partly written last line, from a crash while appending, is ignored
+func (q *Queue) read() error {
+	file, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// lines are read whole, however large the rendered body
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		var r record
+		if jsonErr := json.Unmarshal(line, &r); jsonErr == nil {
+			q.records++
+
+			switch r.Op {
+			case opAdd:
+				if r.Delivery != nil {
+					q.pending[r.Id] = *r.Delivery
+				}
+			case opDone:
+				delete(q.pending, r.Id)
+			}
+			if r.Id > q.lastId {
+				q.lastId = r.Id
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// compact rewrites the log with the pending deliveries and opens it for appending
+func (q *Queue) compact() error {
+	if q.file != nil {
+		q.file.Close()
+	}
+
+	tmp := q.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	for _, d := range q.sorted() {
+		delivery := d
+		if err := writeRecord(w, record{Op: opAdd, Id: d.Id, Delivery: &delivery}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+
+	if err := os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+	q.records = len(q.pending)
+
+	q.file, err = os.OpenFile(q.path, os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+// Enqueue gives the delivery an id and adds it to the queue once it is written to the log
+func (q *Queue) Enqueue(d Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	d.Id = q.lastId + 1
+	d.Queued = d.Queued.Round(0)
+	if err := q.append(record{Op: opAdd, Id: d.Id, Delivery: &d}); err != nil {
+		return err
+	}
+	q.lastId = d.Id
+	q.pending[d.Id] = d
+
+	// wake the consumer, if it is not already due to look
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Done removes the delivery from the queue once it is recorded in the log, the log is compacted
+// when no deliveries are pending or it has grown well beyond them
+func (q *Queue) Done(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.pending[id]; !ok {
+		return fmt.Errorf("delivery %d is not queued", id)
+	}
+	if err := q.append(record{Op: opDone, Id: id}); err != nil {
+		return err
+	}
+	delete(q.pending, id)
+
+	// wake a caller waiting for room
+	select {
+	case q.room <- struct{}{}:
+	default:
+	}
+
+	if len(q.pending) == 0 || (q.records > compactRecords && q.records > compactRatio*len(q.pending)) {
+		return q.compact()
+	}
+	return nil
+}
+
+// Pending returns the deliveries which are not done, in the order they were queued
+func (q *Queue) Pending() []Delivery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.sorted()
+}
+
+// Since returns the pending deliveries queued after the delivery with id, in the order they were queued
+func (q *Queue) Since(id uint64) []Delivery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// ids are given in order so those after id are looked up, unless that is more than are pending
+	// as when the queue is recovered
+	if id > q.lastId || q.lastId-id > uint64(len(q.pending)) {
+		var deliveries []Delivery
+		for _, d := range q.sorted() {
+			if d.Id > id {
+				deliveries = append(deliveries, d)
+			}
+		}
+		return deliveries
+	}
+
+	var deliveries []Delivery
+	for next := id + 1; next <= q.lastId; next++ {
+		if d, ok := q.pending[next]; ok {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries
+}
+
+// Full checks if limit or more deliveries are pending
+func (q *Queue) Full(limit int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending) >= limit
+}
+
+// WaitForRoom returns once fewer than limit deliveries are pending, or the context is cancelled
+func (q *Queue) WaitForRoom(ctx context.Context, limit int) {
+	// room is checked regularly as well as when signalled, as more than one caller may be waiting
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for q.Full(limit) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.room:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Notify signals when a delivery has been queued
+func (q *Queue) Notify() <-chan struct{} {
+	return q.notify
+}
+
+// Close closes the log
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.file.Close()
+}
+
+// append writes the record to the log and syncs it to disk, the lock must be held
+func (q *Queue) append(r record) error {
+	w := bufio.NewWriter(q.file)
+	if err := writeRecord(w, r); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	q.records++
+	return q.file.Sync()
+}
+
+// sorted returns the pending deliveries ordered by id, the lock must be held
+func (q *Queue) sorted() []Delivery {
+	deliveries := make([]Delivery, 0, len(q.pending))
+	for _, d := range q.pending {
+		deliveries = append(deliveries, d)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Id < deliveries[j].Id
+	})
+	return deliveries
+}
+
+func writeRecord(w *bufio.Writer, r record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
@@ -0,0 +1,231 @@
+package queue_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dozer/internal/queue"
+)
+
+func TestQueue(t *testing.T) {
+	file := filepath.Join(t.TempDir(), queue.FILE_NAME)
+
+	q, err := queue.Open(file)
+	if err != nil {
+		t.Fatalf("Unexpected error opening queue which has not been written %v", err)
+	}
+
+	for _, hook := range []string{"hook 1", "hook 2", "hook 3"} {
+		if err := q.Enqueue(queue.Delivery{Hook: hook, Body: "body"}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	select {
+	case <-q.Notify():
+	default:
+		t.Errorf("wanted notification of queued delivery")
+	}
+
+	if err := q.Done(2); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := q.Done(2); err == nil {
+		t.Errorf("wanted error for delivery which is done")
+	}
+
+	// simulate a crash part way through appending
+	if err := q.Close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	f.WriteString(`{"op":"done","id":`)
+	f.Close()
+
+	// recovered on open
+	got, err := queue.Open(file)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	pending := got.Pending()
+	if len(pending) != 2 || pending[0].Id != 1 || pending[1].Id != 3 || pending[1].Hook != "hook 3" || pending[1].Body != "body" {
+		t.Fatalf("wanted deliveries 1 and 3 pending got %+v", pending)
+	}
+
+	// ids are not reused
+	if err := got.Enqueue(queue.Delivery{Hook: "hook 4"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if pending := got.Pending(); pending[len(pending)-1].Id != 4 {
+		t.Errorf("wanted id 4 got %d", pending[len(pending)-1].Id)
+	}
+
+	// the log is compacted once all deliveries are done
+	for _, d := range got.Pending() {
+		if err := got.Done(d.Id); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if info, err := os.Stat(file); err != nil || info.Size() != 0 {
+		t.Errorf("wanted empty log got %v %v", info, err)
+	}
+	got.Close()
+}
+
+func TestWaitForRoom(t *testing.T) {
+	q, err := queue.Open(filepath.Join(t.TempDir(), queue.FILE_NAME))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer q.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := q.Enqueue(queue.Delivery{Hook: "hook"}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if !q.Full(2) || q.Full(3) {
+		t.Fatalf("wanted queue full at 2 deliveries")
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		q.WaitForRoom(context.Background(), 2)
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatalf("wanted wait while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := q.Done(1); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Errorf("wanted wait to end once there is room")
+	}
+
+	// the wait ends when the context is cancelled
+	q.Enqueue(queue.Delivery{Hook: "hook"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.WaitForRoom(ctx, 2)
+}
+
+func TestCompactWhileHeld(t *testing.T) {
+	file := filepath.Join(t.TempDir(), queue.FILE_NAME)
+	q, err := queue.Open(file)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer q.Close()
+
+	// a delivery held in the queue, as for an endpoint which is down
+	if err := q.Enqueue(queue.Delivery{Hook: "held hook"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	for i := 0; i < 2000; i++ {
+		if err := q.Enqueue(queue.Delivery{Hook: "hook"}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		pending := q.Pending()
+		if err := q.Done(pending[len(pending)-1].Id); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines > 1001 {
+		t.Errorf("wanted log compacted got %d records", lines)
+	}
+
+	got, err := queue.Open(file)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer got.Close()
+	if pending := got.Pending(); len(pending) != 1 || pending[0].Hook != "held hook" {
+		t.Errorf("wanted held delivery kept got %+v", pending)
+	}
+}
+
+func TestSince(t *testing.T) {
+	q, err := queue.Open(filepath.Join(t.TempDir(), queue.FILE_NAME))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer q.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := q.Enqueue(queue.Delivery{Hook: "hook", ProcessId: i}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if err := q.Done(4); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	tests := []struct {
+		name string
+		id   uint64
+		want []uint64
+	}{
+		{"Should return all pending", 0, []uint64{1, 2, 3, 5}},
+		{"Should return those queued after, skipping done", 2, []uint64{3, 5}},
+		{"Should return none after the last", 5, nil},
+		{"Should return none after an id not given", 9, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []uint64
+			for _, d := range q.Since(tc.id) {
+				got = append(got, d.Id)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wanted %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLargeDelivery(t *testing.T) {
+	file := filepath.Join(t.TempDir(), queue.FILE_NAME)
+	q, err := queue.Open(file)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// a body larger than the default line buffers, as for a process with long output
+	body := strings.Repeat("x", 17*1024*1024)
+	if err := q.Enqueue(queue.Delivery{Hook: "hook", Body: body}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Close()
+
+	got, err := queue.Open(file)
+	if err != nil {
+		t.Fatalf("Unexpected error opening queue with large delivery %v", err)
+	}
+	defer got.Close()
+	if pending := got.Pending(); len(pending) != 1 || pending[0].Body != body {
+		t.Errorf("wanted large delivery recovered got %d deliveries", len(pending))
+	}
+}
@@ -1,10 +1,15 @@
 package state
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"os"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/spoonboy-io/dozer/internal"
 )
 
 const FILE_NAME = "dozer.state"
@@ -12,9 +17,55 @@ const FILE_NAME = "dozer.state"
 // State holds information about the last poll against the database and
 // processes which where being tracked as "executing" when the application was terminated
 type State struct {
-	LastPollProcessId  int       `json:"lastPollProcessId"`
-	LastPollTimestamp  time.Time `json:"lastPollTimestamp"`
-	ExecutingProcesses []int     `json:"executingProcesses"`
+	LastPollProcessId  int                     `json:"lastPollProcessId"`
+	LastPollTimestamp  time.Time               `json:"lastPollTimestamp"`
+	ExecutingProcesses []int                   `json:"executingProcesses"`
+	StuckProcesses     map[string][]int        `json:"stuckProcesses,omitempty"`
+	Progress           map[int]float64         `json:"progress,omitempty"`
+	Workflows          map[string]*Workflow    `json:"workflows,omitempty"`
+	Thresholds         map[string][]time.Time  `json:"thresholds,omitempty"`
+	Suppressed         map[string]*Suppression `json:"suppressed,omitempty"`
+	Expectations       map[string]*Expectation `json:"expectations,omitempty"`
+	Statuses           map[string]string       `json:"statuses,omitempty"`
+
+	// mu guards Thresholds, Suppressed, Expectations and Statuses which are updated as processes are checked, concurrently
+	mu sync.Mutex
+}
+
+// Expectation is the deadline by which an absence hook expects to see a matching process,
+// LastSeen is when it last saw one
+type Expectation struct {
+	LastSeen time.Time `json:"lastSeen,omitempty"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// Suppression is an open suppression window of a hook, Count is the number of events dropped
+// in the window and Process the last of them, see snapshot
+type Suppression struct {
+	Hook    string            `json:"hook"`
+	Until   time.Time         `json:"until"`
+	Count   int               `json:"count"`
+	Process *internal.Process `json:"process,omitempty"`
+}
+
+// Workflow holds the finished task processes of a workflow run until the workflow process itself
+// finishes, Process is set once it has, see snapshot
+type Workflow struct {
+	Tasks   []Task            `json:"tasks"`
+	Process *internal.Process `json:"process,omitempty"`
+	Updated time.Time         `json:"updated"`
+}
+
+// Task is the summary of a finished workflow task process
+type Task struct {
+	Id        int       `json:"id"`
+	TaskId    int64     `json:"taskId"`
+	TaskName  string    `json:"taskName"`
+	Status    string    `json:"status"`
+	Duration  int64     `json:"duration"`
+	ExitCode  string    `json:"exitCode"`
+	StartDate time.Time `json:"startDate"`
+	EndDate   time.Time `json:"endDate"`
 }
 
 // HasSavedState performs a simple check to discover saved state from an application shutdown
@@ -28,6 +79,9 @@ func (*State) HasSavedState() bool {
 
 // ReadAndParse will read the state file parse the contents and make the required info available in the return
 func (s *State) ReadAndParse() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	data, err := os.ReadFile(FILE_NAME)
 	if err != nil {
 		return err
@@ -40,6 +94,9 @@ func (s *State) ReadAndParse() error {
 
 // CreateAndWrite will marshal state information to JSON and write it to the state file
 func (s *State) CreateAndWrite() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	data, err := json.Marshal(s)
 	if err != nil {
 		return err
@@ -48,7 +105,10 @@ func (s *State) CreateAndWrite() error {
 }
 
 // DeleteProcessFromState removes an element from State.ExecutingProcesses state property by value
+// along with the last progress recorded for it
 func (s *State) DeleteProcessFromState(id int) {
+	delete(s.Progress, id)
+
 	var tmpState []int
 	for i := range s.ExecutingProcesses {
 		if s.ExecutingProcesses[i] == id {
@@ -57,3 +117,240 @@ func (s *State) DeleteProcessFromState(id int) {
 	}
 	s.ExecutingProcesses = tmpState
 }
+
+// SetProgress records the percent of an executing process, returning the last percent recorded
+func (s *State) SetProgress(id int, percent float64) float64 {
+	if s.Progress == nil {
+		s.Progress = map[int]float64{}
+	}
+	last := s.Progress[id]
+	s.Progress[id] = percent
+	return last
+}
+
+// MarkStuck records that a stuck event has been fired by the hook for the executing process
+func (s *State) MarkStuck(hook string, id int) {
+	if s.StuckProcesses == nil {
+		s.StuckProcesses = map[string][]int{}
+	}
+	s.StuckProcesses[hook] = append(s.StuckProcesses[hook], id)
+}
+
+// IsStuck reports if a stuck event has been fired by the hook for the process
+func (s *State) IsStuck(hook string, id int) bool {
+	for _, v := range s.StuckProcesses[hook] {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ClearStuck removes the process from the hook's stuck processes once it is no longer executing
+func (s *State) ClearStuck(hook string, id int) {
+	var tmpStuck []int
+	for _, v := range s.StuckProcesses[hook] {
+		if v != id {
+			tmpStuck = append(tmpStuck, v)
+		}
+	}
+
+	if len(tmpStuck) == 0 {
+		delete(s.StuckProcesses, hook)
+		return
+	}
+	s.StuckProcesses[hook] = tmpStuck
+}
+
+// HoldTask adds a finished task to the workflow identified by key
+func (s *State) HoldTask(key string, task Task) {
+	wf := s.workflow(key)
+	wf.Tasks = append(wf.Tasks, task)
+}
+
+// FinishWorkflow records the finished workflow process against the workflow identified by key
+func (s *State) FinishWorkflow(key string, process *internal.Process) {
+	wf := s.workflow(key)
+	wf.Process = snapshot(process)
+}
+
+// DeleteWorkflow removes the workflow and its held tasks
+func (s *State) DeleteWorkflow(key string) {
+	delete(s.Workflows, key)
+}
+
+// snapshot copies a process to be saved in the state file, without its api key, which may leak secrets, and
+// with its output capped at internal.STATE_OUTPUT_LIMIT bytes so it isn't written in full on every save
+func snapshot(process *internal.Process) *internal.Process {
+	if process == nil {
+		return nil
+	}
+	p := *process
+	p.ApiKey = sql.NullString{}
+	if len(p.Output.String) > internal.STATE_OUTPUT_LIMIT {
+		p.Output.String = p.Output.String[:internal.STATE_OUTPUT_LIMIT]
+	}
+	return &p
+}
+
+func (s *State) workflow(key string) *Workflow {
+	if s.Workflows == nil {
+		s.Workflows = map[string]*Workflow{}
+	}
+	wf, ok := s.Workflows[key]
+	if !ok {
+		wf = &Workflow{}
+		s.Workflows[key] = wf
+	}
+	wf.Updated = time.Now().Round(0)
+	return wf
+}
+
+// AddOccurrence records an occurrence at the time against the threshold key, dropping those which are no
+// longer within the window. Once count occurrences are held they are cleared and true is returned
+func (s *State) AddOccurrence(key string, at time.Time, within time.Duration, count int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var occurrences []time.Time
+	for _, o := range s.Thresholds[key] {
+		if at.Sub(o) < within {
+			occurrences = append(occurrences, o)
+		}
+	}
+	occurrences = append(occurrences, at.Round(0))
+
+	if len(occurrences) >= count {
+		delete(s.Thresholds, key)
+		return true
+	}
+
+	if s.Thresholds == nil {
+		s.Thresholds = map[string][]time.Time{}
+	}
+	s.Thresholds[key] = occurrences
+	return false
+}
+
+// PruneOccurrences drops the occurrences which are no longer within the window of the hook they were
+// counted for, windows are keyed by hook description. Keys left with none, or of hooks not in windows,
+// are removed so counts which never reach their threshold are not kept
+func (s *State) PruneOccurrences(windows map[string]time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, occurrences := range s.Thresholds {
+		// keys are the hook description, followed by the by variables
+		hook, found := "", false
+		for description := range windows {
+			if (key == description || strings.HasPrefix(key, description+"|")) && len(description) >= len(hook) {
+				hook, found = description, true
+			}
+		}
+		if !found {
+			delete(s.Thresholds, key)
+			continue
+		}
+
+		var kept []time.Time
+		for _, o := range occurrences {
+			if now.Sub(o) < windows[hook] {
+				kept = append(kept, o)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.Thresholds, key)
+			continue
+		}
+		s.Thresholds[key] = kept
+	}
+}
+
+// Suppress reports if an event for the hook should be dropped as a window is open for the key, counting it
+// against the window. If no window is open one is opened until the time given and false is returned
+func (s *State) Suppress(hook, key string, until time.Time, process *internal.Process) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sup, ok := s.Suppressed[key]; ok && time.Now().Before(sup.Until) {
+		sup.Count++
+		sup.Process = snapshot(process)
+		return true
+	}
+
+	if s.Suppressed == nil {
+		s.Suppressed = map[string]*Suppression{}
+	}
+	s.Suppressed[key] = &Suppression{Hook: hook, Until: until.Round(0)}
+	return false
+}
+
+// CloseSuppressions removes and returns the suppression windows which have closed
+func (s *State) CloseSuppressions() []*Suppression {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var closed []*Suppression
+	for key, sup := range s.Suppressed {
+		if !time.Now().Before(sup.Until) {
+			closed = append(closed, sup)
+			delete(s.Suppressed, key)
+		}
+	}
+	return closed
+}
+
+// Seen records that the absence hook saw a matching process, setting its next deadline
+func (s *State) Seen(hook string, at, deadline time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expectation(hook).LastSeen = at.Round(0)
+	s.expectation(hook).Deadline = deadline.Round(0)
+}
+
+// SetDeadline sets the deadline of the absence hook
+func (s *State) SetDeadline(hook string, deadline time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expectation(hook).Deadline = deadline.Round(0)
+}
+
+// Expected returns the expectation of the absence hook, if it has one
+func (s *State) Expected(hook string) (Expectation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exp, ok := s.Expectations[hook]
+	if !ok {
+		return Expectation{}, false
+	}
+	return *exp, true
+}
+
+func (s *State) expectation(hook string) *Expectation {
+	if s.Expectations == nil {
+		s.Expectations = map[string]*Expectation{}
+	}
+	exp, ok := s.Expectations[hook]
+	if !ok {
+		exp = &Expectation{}
+		s.Expectations[hook] = exp
+	}
+	return exp
+}
+
+// SetStatus records the status of the last finished process with the correlation key, returning the status
+// recorded before it
+func (s *State) SetStatus(key, status string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Statuses == nil {
+		s.Statuses = map[string]string{}
+	}
+	last := s.Statuses[key]
+	s.Statuses[key] = status
+	return last
+}
@@ -1,12 +1,15 @@
 package state_test
 
 import (
+	"database/sql"
 	"encoding/json"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/spoonboy-io/dozer/internal"
 	"github.com/spoonboy-io/dozer/internal/state"
 )
 
@@ -120,6 +123,205 @@ func Test_DeleteProcessFromState(t *testing.T) {
 	}
 }
 
+func Test_SetProgress(t *testing.T) {
+	st := &state.State{
+		ExecutingProcesses: []int{3, 4},
+	}
+
+	if last := st.SetProgress(3, 25); last != 0 {
+		t.Errorf("failed got %v wanted %v", last, 0)
+	}
+	if last := st.SetProgress(3, 60); last != 25 {
+		t.Errorf("failed got %v wanted %v", last, 25)
+	}
+	st.SetProgress(4, 10)
+
+	st.DeleteProcessFromState(3)
+	wantProgress := map[int]float64{4: 10}
+	if !reflect.DeepEqual(st.Progress, wantProgress) {
+		t.Errorf("failed got %v wanted %v", st.Progress, wantProgress)
+	}
+}
+
+func Test_Workflows(t *testing.T) {
+	st := &state.State{}
+
+	st.HoldTask("3/12/0", state.Task{Id: 11, TaskName: "Install"})
+	st.HoldTask("3/12/0", state.Task{Id: 12, TaskName: "Configure"})
+	st.HoldTask("3/13/0", state.Task{Id: 21, TaskName: "Install"})
+	output := strings.Repeat("x", internal.STATE_OUTPUT_LIMIT+1)
+	st.FinishWorkflow("3/12/0", &internal.Process{
+		Id:     10,
+		ApiKey: sql.NullString{String: "secret", Valid: true},
+		Output: sql.NullString{String: output, Valid: true},
+	})
+
+	wf := st.Workflows["3/12/0"]
+	if len(wf.Tasks) != 2 || wf.Process == nil || wf.Process.Id != 10 {
+		t.Errorf("failed got %+v", wf)
+	}
+
+	// the api key is not saved and the output is capped
+	if wf.Process.ApiKey.String != "" || len(wf.Process.Output.String) != internal.STATE_OUTPUT_LIMIT {
+		t.Errorf("failed got api key %q and %d bytes of output", wf.Process.ApiKey.String, len(wf.Process.Output.String))
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("failed api key written to state %s", data)
+	}
+	if st.Workflows["3/13/0"].Process != nil {
+		t.Errorf("failed workflow finished for another key")
+	}
+
+	st.DeleteWorkflow("3/12/0")
+	if len(st.Workflows) != 1 {
+		t.Errorf("failed got %v workflows wanted %v", len(st.Workflows), 1)
+	}
+}
+
+func Test_AddOccurrence(t *testing.T) {
+	st := &state.State{}
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		key   string
+		after time.Duration
+		want  bool
+	}{
+		{"a", 0, false},
+		{"b", time.Minute, false},
+		{"a", 20 * time.Minute, false},
+		// the first occurrence of a has left the window
+		{"a", 40 * time.Minute, false},
+		{"a", 45 * time.Minute, true},
+		// fired so counting starts again
+		{"a", 46 * time.Minute, false},
+	}
+
+	for _, tc := range testCases {
+		got := st.AddOccurrence(tc.key, start.Add(tc.after), 30*time.Minute, 3)
+		if got != tc.want {
+			t.Errorf("failed %s at %v got %v wanted %v", tc.key, tc.after, got, tc.want)
+		}
+	}
+
+	if len(st.Thresholds["a"]) != 1 || len(st.Thresholds["b"]) != 1 {
+		t.Errorf("failed got %v", st.Thresholds)
+	}
+}
+
+func Test_PruneOccurrences(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	st := &state.State{Thresholds: map[string][]time.Time{
+		"hook|instanceId=1":      {now.Add(-time.Hour), now.Add(-10 * time.Minute)},
+		"hook|instanceId=2":      {now.Add(-time.Hour)},
+		"hook|long|instanceId=3": {now.Add(-time.Hour)},
+		"hook":                   {now.Add(-40 * time.Minute)},
+		"removed hook":           {now},
+	}}
+
+	st.PruneOccurrences(map[string]time.Duration{"hook": 30 * time.Minute, "hook|long": 2 * time.Hour}, now)
+
+	want := map[string][]time.Time{
+		"hook|instanceId=1":      {now.Add(-10 * time.Minute)},
+		"hook|long|instanceId=3": {now.Add(-time.Hour)},
+	}
+	if !reflect.DeepEqual(st.Thresholds, want) {
+		t.Errorf("failed got %v wanted %v", st.Thresholds, want)
+	}
+}
+
+func Test_Suppress(t *testing.T) {
+	st := &state.State{}
+	until := time.Now().Add(time.Minute)
+
+	if st.Suppress("hook", "hook|a", until, &internal.Process{Id: 1}) {
+		t.Errorf("failed first event suppressed")
+	}
+	if !st.Suppress("hook", "hook|a", until, &internal.Process{Id: 2, ApiKey: sql.NullString{String: "secret", Valid: true}}) {
+		t.Errorf("failed repeat event not suppressed")
+	}
+	if st.Suppress("hook", "hook|b", time.Now(), &internal.Process{Id: 3}) {
+		t.Errorf("failed event for other key suppressed")
+	}
+
+	// b's window has closed
+	closed := st.CloseSuppressions()
+	if len(closed) != 1 || closed[0].Count != 0 {
+		t.Errorf("failed got %v closed windows wanted %v", len(closed), 1)
+	}
+
+	sup := st.Suppressed["hook|a"]
+	if sup.Count != 1 || sup.Process.Id != 2 || sup.Process.ApiKey.String != "" {
+		t.Errorf("failed got %+v", sup)
+	}
+}
+
+func Test_Expectations(t *testing.T) {
+	st := &state.State{}
+	seen := time.Date(2022, 6, 1, 2, 0, 0, 0, time.UTC)
+
+	if _, ok := st.Expected("hook"); ok {
+		t.Errorf("failed expectation found before set")
+	}
+
+	st.SetDeadline("hook", seen)
+	st.Seen("hook", seen, seen.Add(26*time.Hour))
+
+	want := state.Expectation{LastSeen: seen, Deadline: seen.Add(26 * time.Hour)}
+	if got, ok := st.Expected("hook"); !ok || got != want {
+		t.Errorf("failed got %v wanted %v", got, want)
+	}
+}
+
+func Test_SetStatus(t *testing.T) {
+	st := &state.State{}
+
+	if last := st.SetStatus("hook|taskId=4", "failed"); last != "" {
+		t.Errorf("failed got %v wanted %v", last, "")
+	}
+	if last := st.SetStatus("hook|taskId=4", "complete"); last != "failed" {
+		t.Errorf("failed got %v wanted %v", last, "failed")
+	}
+}
+
+func Test_StuckProcesses(t *testing.T) {
+	st := &state.State{}
+
+	if st.IsStuck("test hook", 4) {
+		t.Errorf("failed process should not be stuck before it is marked")
+	}
+
+	st.MarkStuck("test hook", 4)
+	st.MarkStuck("test hook", 5)
+	st.MarkStuck("other hook", 4)
+
+	if !st.IsStuck("test hook", 4) || !st.IsStuck("other hook", 4) {
+		t.Errorf("failed process should be stuck once marked")
+	}
+
+	st.ClearStuck("test hook", 4)
+	if st.IsStuck("test hook", 4) {
+		t.Errorf("failed process should not be stuck once cleared")
+	}
+
+	wantStuck := map[string][]int{
+		"test hook":  {5},
+		"other hook": {4},
+	}
+	if !reflect.DeepEqual(st.StuckProcesses, wantStuck) {
+		t.Errorf("failed got %v wanted %v", st.StuckProcesses, wantStuck)
+	}
+
+	st.ClearStuck("other hook", 4)
+	if _, ok := st.StuckProcesses["other hook"]; ok {
+		t.Errorf("failed hook with no stuck processes should be removed")
+	}
+}
+
 // Actions is failing on time, though all looks good and passes locally (mac)
 // if we end up with fail we will further inspect via this function and if only fail
 // id we are false
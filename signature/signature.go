@@ -0,0 +1,117 @@
+// Package signature signs webhook requests sent by Dozer and verifies them in receiving services.
+//
+// The signature is the HMAC of the timestamp and request body, joined by a '.', using the secret
+// shared with the hook. It is sent hex encoded in the X-Dozer-Signature header, prefixed with the
+// algorithm, e.g. `sha256=5257a869...`, and the timestamp in the X-Dozer-Timestamp header as unix
+// seconds. Receivers should reject requests with timestamps outside a tolerance, so a captured
+// request can't be replayed
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// request headers which carry the signature and timestamp
+const (
+	HEADER_SIGNATURE = "X-Dozer-Signature"
+	HEADER_TIMESTAMP = "X-Dozer-Timestamp"
+)
+
+// supported HMAC algorithms
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+)
+
+var (
+	ERR_BAD_ALGORITHM = errors.New("signature algorithm should be sha256 or sha512")
+	ERR_NO_SIGNATURE  = errors.New("request is not signed")
+	ERR_BAD_SIGNATURE = errors.New("signature does not match")
+	ERR_BAD_TIMESTAMP = errors.New("timestamp is not valid or outside the tolerance")
+)
+
+// Sign returns the signature of the body at the timestamp, prefixed with the algorithm
+func Sign(algorithm, secret string, timestamp time.Time, body []byte) (string, error) {
+	mac, err := newMac(algorithm, secret)
+	if err != nil {
+		return "", err
+	}
+
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("%s=%s", algorithm, hex.EncodeToString(mac.Sum(nil))), nil
+}
+
+// Verify checks the signature and timestamp header values against the body. The timestamp must be
+// within tolerance of now, a tolerance of 0 does not check it
+func Verify(secret, sig, timestamp string, body []byte, tolerance time.Duration) error {
+	if sig == "" || timestamp == "" {
+		return ERR_NO_SIGNATURE
+	}
+
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ERR_BAD_TIMESTAMP
+	}
+	at := time.Unix(secs, 0)
+	if tolerance > 0 {
+		if age := time.Since(at); age > tolerance || age < -tolerance {
+			return ERR_BAD_TIMESTAMP
+		}
+	}
+
+	parts := strings.SplitN(sig, "=", 2)
+	if len(parts) != 2 {
+		return ERR_BAD_SIGNATURE
+	}
+	want, err := Sign(parts[0], secret, at, body)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return ERR_BAD_SIGNATURE
+	}
+	return nil
+}
+
+// VerifyRequest checks the signature of the request and returns its body, the request body is
+// replaced so it can be read again by the caller's handler
+func VerifyRequest(r *http.Request, secret string, tolerance time.Duration) ([]byte, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if err := Verify(secret, r.Header.Get(HEADER_SIGNATURE), r.Header.Get(HEADER_TIMESTAMP), body, tolerance); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func newMac(algorithm, secret string) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return hmac.New(sha256.New, []byte(secret)), nil
+	case SHA512:
+		return hmac.New(sha512.New, []byte(secret)), nil
+	}
+	return nil, ERR_BAD_ALGORITHM
+}
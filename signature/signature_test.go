@@ -0,0 +1,88 @@
+package signature_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dozer/signature"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id": 1}`)
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	sig256, err := signature.Sign(signature.SHA256, "secret", now, body)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.HasPrefix(sig256, "sha256=") || len(sig256) != len("sha256=")+64 {
+		t.Errorf("wanted hex sha256 signature got %s", sig256)
+	}
+	sig512, err := signature.Sign(signature.SHA512, "secret", now, body)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	old := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+	oldSig, _ := signature.Sign(signature.SHA256, "secret", now.Add(-10*time.Minute), body)
+
+	testCases := []struct {
+		name      string
+		secret    string
+		sig       string
+		timestamp string
+		body      []byte
+		tolerance time.Duration
+		wantErr   error
+	}{
+		{"sha256", "secret", sig256, timestamp, body, time.Minute, nil},
+		{"sha512", "secret", sig512, timestamp, body, time.Minute, nil},
+		{"wrong secret", "other", sig256, timestamp, body, time.Minute, signature.ERR_BAD_SIGNATURE},
+		{"body changed", "secret", sig256, timestamp, []byte(`{"id": 2}`), time.Minute, signature.ERR_BAD_SIGNATURE},
+		{"timestamp changed", "secret", sig256, old, body, 0, signature.ERR_BAD_SIGNATURE},
+		{"replayed", "secret", oldSig, old, body, time.Minute, signature.ERR_BAD_TIMESTAMP},
+		{"tolerance not checked", "secret", oldSig, old, body, 0, nil},
+		{"bad timestamp", "secret", sig256, "yesterday", body, time.Minute, signature.ERR_BAD_TIMESTAMP},
+		{"not signed", "secret", "", timestamp, body, time.Minute, signature.ERR_NO_SIGNATURE},
+		{"bad algorithm", "secret", "md5=abc", timestamp, body, time.Minute, signature.ERR_BAD_ALGORITHM},
+		{"no algorithm", "secret", "abc", timestamp, body, time.Minute, signature.ERR_BAD_SIGNATURE},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := signature.Verify(tc.secret, tc.sig, tc.timestamp, tc.body, tc.tolerance)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("wanted %v got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	body := `{"id": 1}`
+	now := time.Now()
+	sig, _ := signature.Sign(signature.SHA256, "secret", now, []byte(body))
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set(signature.HEADER_SIGNATURE, sig)
+	req.Header.Set(signature.HEADER_TIMESTAMP, strconv.FormatInt(now.Unix(), 10))
+
+	got, err := signature.VerifyRequest(req, "secret", time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if string(got) != body {
+		t.Errorf("wanted %s got %s", body, got)
+	}
+
+	// the body can be read again
+	buf := make([]byte, len(body))
+	if n, _ := req.Body.Read(buf); string(buf[:n]) != body {
+		t.Errorf("wanted body restored got %s", buf[:n])
+	}
+}